@@ -1,5 +1,70 @@
 var API_URL = "https://ng_grok_url/api/webhook/sheets";
 
+// The shared secret lives in Project Settings -> Script Properties as
+// WEBHOOK_SECRET, it must be one of the backend's WEBHOOK_SECRETS. The
+// delivery ID and API_URL's query are signed along with the body.
+function signRequest(body, deliveryId) {
+  var secret = PropertiesService.getScriptProperties().getProperty("WEBHOOK_SECRET");
+  var timestamp = Math.floor(Date.now() / 1000).toString();
+  var signed = timestamp + "." + deliveryId + "." + canonicalQuery(API_URL) + "." + body;
+
+  var bytes = Utilities.computeHmacSha256Signature(signed, secret, Utilities.Charset.UTF_8);
+  var signature = bytes.map(function (b) {
+    return ("0" + (b & 0xff).toString(16)).slice(-2);
+  }).join("");
+
+  return {
+    "X-Signature": "sha256=" + signature,
+    "X-Timestamp": timestamp
+  };
+}
+
+// The URL's query parameters as sorted, unescaped "key=value" pairs joined
+// by "&", the way the backend rebuilds them.
+function canonicalQuery(url) {
+  var query = url.split("?")[1];
+  if (!query) return "";
+
+  return query.split("&").filter(function (pair) { return pair !== ""; }).map(function (pair) {
+    var parts = pair.split("=");
+    var decode = function (s) { return decodeURIComponent((s || "").replace(/\+/g, " ")); };
+    return decode(parts[0]) + "=" + decode(parts.slice(1).join("="));
+  }).sort().join("&");
+}
+
+
+// Sends a batch, retrying network errors and 5xx responses. Every attempt
+// carries the same delivery ID so the backend applies the batch only once.
+function sendBatch(body) {
+  var deliveryId = Utilities.getUuid();
+  var response;
+
+  for (var attempt = 1; attempt <= 3; attempt++) {
+    var headers = signRequest(body, deliveryId);
+    headers["X-Delivery-ID"] = deliveryId;
+
+    try {
+      response = UrlFetchApp.fetch(API_URL, {
+        "method": "post",
+        "contentType": "application/json",
+        "headers": headers,
+        "payload": body,
+        "muteHttpExceptions": true
+      });
+      if (response.getResponseCode() < 500) return response;
+    } catch (error) {
+      if (attempt === 3) throw error;
+    }
+    Utilities.sleep(1000 * attempt);
+  }
+  return response;
+}
+
+
+// Columns the backend fills in (read_only in sync.json). They are never
+// sent, the UUID travels with every item anyway.
+var READ_ONLY_COLUMNS = ["UUID", "Last Updated", "Updated By", "Version"];
+
 
 function handleEdit(e) {
   var range = e.range;
@@ -24,6 +89,7 @@ function handleEdit(e) {
  
   // Get the headers to map Column Index -> Field Name (e.g., "Price")
   var headers = sheet.getRange(1, 1, 1, lastCol).getValues()[0];
+  var versionIndex = headers.indexOf("Version");
 
 
   // Read the entire affected grid in ONE call (Super Fast)
@@ -66,8 +132,8 @@ function handleEdit(e) {
     for (var j = 0; j < headers.length; j++) {
       var fieldName = headers[j];
      
-      // Skip irrelevant columns or empty headers
-      if (!fieldName || fieldName === "Last Updated") continue;
+      // Skip empty headers and the columns only the backend writes
+      if (!fieldName || READ_ONLY_COLUMNS.indexOf(fieldName) > -1) continue;
 
 
       // Identify the value to send
@@ -82,12 +148,19 @@ function handleEdit(e) {
       var isInsideEditRange = (colIndex >= startCol && colIndex < startCol + numCols);
      
       if (isNewRow || isInsideEditRange) {
-        payload.push({
+        var item = {
           "uuid": uuid.toString(),
           "field": fieldName,
           "value": cellValue,
           "user_email": currentUser
-        });
+        };
+
+        // The version this row had when it was edited, lets the backend
+        // spot edits made elsewhere in the meantime
+        if (!isNewRow && versionIndex > -1 && typeof values[i][versionIndex] === "number") {
+          item["version"] = values[i][versionIndex];
+        }
+        payload.push(item);
       }
     }
   }
@@ -103,17 +176,117 @@ function handleEdit(e) {
   if (payload.length === 0) return;
 
 
-  var options = {
-    "method": "post",
-    "contentType": "application/json",
-    "payload": JSON.stringify(payload),
-    "muteHttpExceptions": true
-  };
-
+  var body = JSON.stringify(payload);
 
   try {
-    UrlFetchApp.fetch(API_URL, options);
+    var response = sendBatch(body);
+    if (response.getResponseCode() !== 200) {
+      Logger.log("Sync Failed: HTTP " + response.getResponseCode() + " " + response.getContentText());
+      return;
+    }
+
+    var result = JSON.parse(response.getContentText());
+
+    // 7. KEEP THE VERSION COLUMN CURRENT
+    // The backend doesn't write our own edits back, so it returns the new versions
+    if (versionIndex > -1 && result.versions) {
+      var versionCells = [];
+      for (var k = 0; k < numRows; k++) {
+        var v = result.versions[values[k][0]];
+        versionCells.push([v !== undefined ? v : values[k][versionIndex]]);
+      }
+      sheet.getRange(startRow, versionIndex + 1, numRows, 1).setValues(versionCells);
+    }
+
+    // 8. REPORT ITEMS THE BACKEND DIDN'T SAVE
+    var failed = (result.results || []).filter(function (item) { return item.status !== "applied" && item.status !== "unchanged"; });
+    if (failed.length > 0) {
+      Logger.log("Not saved: " + JSON.stringify(failed));
+      SpreadsheetApp.getActive().toast(
+        failed.length + " edit(s) not saved: " + failed[0].field + " " + failed[0].error,
+        "Sync"
+      );
+    }
   } catch (error) {
     Logger.log("Sync Failed: " + error.toString());
   }
 }
+
+
+// Install as an "On change" trigger. Deleting rows doesn't fire onEdit, so
+// the UUID column is compared with a snapshot taken on the previous change
+// and the UUIDs that disappeared are sent as deletions. The backend can
+// also catch deletions this misses when reconciling (SHEET_MISSING_ROWS).
+function handleChange(e) {
+  var sheet = SpreadsheetApp.getActive().getSheetByName("Sheet1");
+  if (!sheet) return;
+
+  var before = loadRowIds();
+  var current = readRowIds(sheet);
+  saveRowIds(current);
+
+  if (e.changeType !== "REMOVE_ROW" || !before) return;
+
+  var present = {};
+  current.forEach(function (id) { present[id] = true; });
+
+  var currentUser = Session.getActiveUser().getEmail() || "anonymous_sheet_user";
+  var payload = before.filter(function (id) { return !present[id]; }).map(function (id) {
+    return { "uuid": id, "action": "delete", "user_email": currentUser };
+  });
+  if (payload.length === 0) return;
+
+  try {
+    var response = sendBatch(JSON.stringify(payload));
+    if (response.getResponseCode() !== 200) {
+      Logger.log("Delete Sync Failed: HTTP " + response.getResponseCode() + " " + response.getContentText());
+    }
+  } catch (error) {
+    Logger.log("Delete Sync Failed: " + error.toString());
+  }
+}
+
+
+function readRowIds(sheet) {
+  var lastRow = sheet.getLastRow();
+  if (lastRow < 2) return [];
+
+  return sheet.getRange(2, 1, lastRow - 1, 1).getValues()
+    .map(function (row) { return row[0] ? row[0].toString() : ""; })
+    .filter(function (id) { return id !== ""; });
+}
+
+
+// The snapshot is split over several document properties, each one is
+// limited to about 9KB.
+var ROW_IDS_CHUNK = 8000;
+
+function saveRowIds(ids) {
+  var props = PropertiesService.getDocumentProperties();
+  var json = JSON.stringify(ids);
+  var chunks = {};
+  var count = 0;
+
+  for (var i = 0; i < json.length; i += ROW_IDS_CHUNK) {
+    chunks["ROW_IDS_" + count] = json.substring(i, i + ROW_IDS_CHUNK);
+    count++;
+  }
+  chunks["ROW_IDS_COUNT"] = count.toString();
+  props.setProperties(chunks);
+}
+
+function loadRowIds() {
+  var props = PropertiesService.getDocumentProperties();
+  var count = parseInt(props.getProperty("ROW_IDS_COUNT"), 10);
+  if (!count) return null;
+
+  var json = "";
+  for (var i = 0; i < count; i++) {
+    json += props.getProperty("ROW_IDS_" + i) || "";
+  }
+  try {
+    return JSON.parse(json);
+  } catch (error) {
+    return null;
+  }
+}
@@ -0,0 +1,87 @@
+package cdc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/go-mysql-org/go-mysql/canal"
+)
+
+// untracked columns change with every write, history records the author
+// with each change instead.
+var untracked = map[string]bool{
+	database.VersionColumn: true,
+	"updated_at":           true,
+	"last_updated_by":      true,
+}
+
+// rowChanges lists the fields one row of e changed, with their old and new
+// values. before is the before image of an update, after the row as it is
+// in e (the deleted row for deletes).
+func (h *MyEventHandler) rowChanges(e *canal.RowsEvent, rowID string, before, after map[string]interface{}) []database.Change {
+	if e.Header == nil {
+		return nil
+	}
+
+	action := e.Action
+	if action == canal.DeleteAction {
+		before, after = after, nil
+	}
+	if col, ok := h.SoftDelete[e.Table.Name]; ok && action == canal.UpdateAction {
+		switch {
+		case before[col] == nil && after[col] != nil:
+			action = canal.DeleteAction
+		case before[col] != nil && after[col] == nil:
+			action = "restore"
+		}
+	}
+
+	origin := h.origin
+	if origin == "" {
+		origin = database.OriginSQL
+	}
+
+	// Writes from the sheet and the API always set last_updated_by. Plain
+	// SQL and drift heals usually don't, an unchanged value is someone
+	// else's.
+	author, _ := after["last_updated_by"].(string)
+	if after == nil {
+		author, _ = before["last_updated_by"].(string)
+	} else if before != nil && (h.origin == "" || h.origin == database.OriginDrift) && fmt.Sprint(before["last_updated_by"]) == author {
+		author = ""
+	}
+
+	var changes []database.Change
+	for _, col := range e.Table.Columns {
+		if untracked[col.Name] {
+			continue
+		}
+		oldValue, newValue := auditValue(before[col.Name]), auditValue(after[col.Name])
+		if (oldValue == nil && newValue == nil) || (oldValue != nil && newValue != nil && *oldValue == *newValue) {
+			continue
+		}
+		changes = append(changes, database.Change{
+			Table:      e.Table.Name,
+			RowID:      rowID,
+			Action:     action,
+			Field:      col.Name,
+			OldValue:   oldValue,
+			NewValue:   newValue,
+			Author:     author,
+			Origin:     origin,
+			BinlogFile: h.binlogFile,
+			BinlogPos:  e.Header.LogPos,
+			ChangedAt:  time.Unix(int64(e.Header.Timestamp), 0),
+		})
+	}
+	return changes
+}
+
+func auditValue(v interface{}) *string {
+	if v == nil {
+		return nil
+	}
+	s := fmt.Sprintf("%v", v)
+	return &s
+}
@@ -0,0 +1,93 @@
+package cdc
+
+import (
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+)
+
+// Batch collects events over a short window and collapses repeated changes
+// to the same row into its latest state, so a bulk UPDATE becomes one sheet
+// write per row instead of one request per binlog event. A row inserted in
+// the batch stays an insert until it is deleted.
+type Batch struct {
+	// Checkpoint is the latest checkpoint seen. It may only be saved once
+	// every event added before it has been flushed.
+	Checkpoint *SyncEvent
+
+	order  []string
+	latest map[string]SyncEvent
+	// inserted marks rows whose first change in the batch is an insert.
+	inserted map[string]bool
+}
+
+func NewBatch() *Batch {
+	return &Batch{latest: map[string]SyncEvent{}, inserted: map[string]bool{}}
+}
+
+func (b *Batch) Add(event SyncEvent) {
+	if event.Action == CheckpointAction {
+		b.Checkpoint = &event
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s", event.Table, event.RowID)
+	prev, seen := b.latest[key]
+	switch {
+	case !seen:
+		b.order = append(b.order, key)
+		b.inserted[key] = event.Action == canal.InsertAction
+	case event.Action == canal.DeleteAction:
+		// Kept even for a row inserted in the batch: after a restart the
+		// insert may be read again when it already reached the sheet.
+	case b.inserted[key]:
+		// Still a new row, appended with its latest values.
+		event.Action = canal.InsertAction
+	case event.Action == RefreshAction && prev.Action != RefreshAction:
+		// The earlier change still has to be written in full, with the
+		// row as it is now.
+		event.Action = canal.UpdateAction
+	}
+	b.latest[key] = event
+}
+
+// Len returns the number of distinct rows in the batch.
+func (b *Batch) Len() int {
+	return len(b.order)
+}
+
+// Empty reports whether there is neither a row nor a checkpoint to flush.
+func (b *Batch) Empty() bool {
+	return len(b.order) == 0 && b.Checkpoint == nil
+}
+
+// Events returns the latest event of each row, in order of first change.
+func (b *Batch) Events() []SyncEvent {
+	events := make([]SyncEvent, 0, len(b.order))
+	for _, key := range b.order {
+		events = append(events, b.latest[key])
+	}
+	return events
+}
+
+// Retain empties the batch except for events, which were neither written to
+// the sheet nor stored in the outbox and have to be flushed again. The
+// checkpoint is kept with them: it can't be saved before they are, and a
+// later one that replaces it can't either.
+func (b *Batch) Retain(events []SyncEvent) {
+	checkpoint := b.Checkpoint
+	b.Reset()
+	for _, e := range events {
+		b.Add(e)
+	}
+	if len(events) > 0 {
+		b.Checkpoint = checkpoint
+	}
+}
+
+func (b *Batch) Reset() {
+	b.Checkpoint = nil
+	b.order = nil
+	b.latest = map[string]SyncEvent{}
+	b.inserted = map[string]bool{}
+}
@@ -0,0 +1,170 @@
+package cdc
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+func rowEvent(id, action string, price float64) SyncEvent {
+	return SyncEvent{Source: "MYSQL", Table: "product", RowID: id, Action: action, Data: map[string]interface{}{"uuid": id, "price": price}}
+}
+
+func checkpointAt(pos uint32) SyncEvent {
+	return SyncEvent{Source: "MYSQL", Action: CheckpointAction, Position: mysql.Position{Name: "mysql-bin.000001", Pos: pos}}
+}
+
+// flushed is what a batch writes for each row: its action and price.
+type flushed struct {
+	id     string
+	action string
+	price  float64
+}
+
+func TestBatchCoalesces(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []SyncEvent
+		want   []flushed
+	}{
+		{
+			name:   "updates keep the last",
+			events: []SyncEvent{rowEvent("u-1", canal.UpdateAction, 1), rowEvent("u-1", canal.UpdateAction, 2), rowEvent("u-1", canal.UpdateAction, 3)},
+			want:   []flushed{{"u-1", canal.UpdateAction, 3}},
+		},
+		{
+			name:   "insert then update is an insert",
+			events: []SyncEvent{rowEvent("u-1", canal.InsertAction, 1), rowEvent("u-1", canal.UpdateAction, 2)},
+			want:   []flushed{{"u-1", canal.InsertAction, 2}},
+		},
+		{
+			name:   "insert then refresh is an insert",
+			events: []SyncEvent{rowEvent("u-1", canal.InsertAction, 1), rowEvent("u-1", RefreshAction, 2)},
+			want:   []flushed{{"u-1", canal.InsertAction, 2}},
+		},
+		{
+			// Nothing is appended, and the sheet has nothing to delete
+			// unless the insert reached it before a restart.
+			name:   "insert then delete is a delete",
+			events: []SyncEvent{rowEvent("u-1", canal.InsertAction, 1), rowEvent("u-1", canal.UpdateAction, 2), rowEvent("u-1", canal.DeleteAction, 2)},
+			want:   []flushed{{"u-1", canal.DeleteAction, 2}},
+		},
+		{
+			name:   "update then delete is a delete",
+			events: []SyncEvent{rowEvent("u-1", canal.UpdateAction, 1), rowEvent("u-1", canal.DeleteAction, 1)},
+			want:   []flushed{{"u-1", canal.DeleteAction, 1}},
+		},
+		{
+			name:   "delete then insert is an insert",
+			events: []SyncEvent{rowEvent("u-1", canal.DeleteAction, 1), rowEvent("u-1", canal.InsertAction, 2)},
+			want:   []flushed{{"u-1", canal.InsertAction, 2}},
+		},
+		{
+			name:   "update then refresh is written in full",
+			events: []SyncEvent{rowEvent("u-1", canal.UpdateAction, 1), rowEvent("u-1", RefreshAction, 2)},
+			want:   []flushed{{"u-1", canal.UpdateAction, 2}},
+		},
+		{
+			name:   "refreshes stay refreshes",
+			events: []SyncEvent{rowEvent("u-1", RefreshAction, 1), rowEvent("u-1", RefreshAction, 2)},
+			want:   []flushed{{"u-1", RefreshAction, 2}},
+		},
+		{
+			name: "rows in order of first change",
+			events: []SyncEvent{
+				rowEvent("u-2", canal.UpdateAction, 1), rowEvent("u-1", canal.InsertAction, 1),
+				rowEvent("u-2", canal.UpdateAction, 2), rowEvent("u-3", canal.DeleteAction, 0),
+			},
+			want: []flushed{{"u-2", canal.UpdateAction, 2}, {"u-1", canal.InsertAction, 1}, {"u-3", canal.DeleteAction, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBatch()
+			for _, e := range tt.events {
+				b.Add(e)
+			}
+
+			events := b.Events()
+			if b.Len() != len(tt.want) || len(events) != len(tt.want) {
+				t.Fatalf("batch has %d rows, want %d", len(events), len(tt.want))
+			}
+			for i, e := range events {
+				got := flushed{e.RowID, e.Action, e.Data["price"].(float64)}
+				if got != tt.want[i] {
+					t.Fatalf("row %d is %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchSeparatesTables(t *testing.T) {
+	b := NewBatch()
+	b.Add(rowEvent("1", canal.UpdateAction, 1))
+	other := rowEvent("1", canal.UpdateAction, 2)
+	other.Table = "category"
+	b.Add(other)
+
+	if b.Len() != 2 {
+		t.Fatalf("batch has %d rows, want one per table", b.Len())
+	}
+}
+
+func TestBatchCheckpoint(t *testing.T) {
+	b := NewBatch()
+	if !b.Empty() {
+		t.Fatal("new batch is not empty")
+	}
+
+	b.Add(checkpointAt(100))
+	if b.Empty() || b.Len() != 0 {
+		t.Fatalf("checkpoint only: Empty() = %v, Len() = %d", b.Empty(), b.Len())
+	}
+
+	b.Add(rowEvent("u-1", canal.UpdateAction, 1))
+	b.Add(checkpointAt(200))
+	if b.Checkpoint == nil || b.Checkpoint.Position.Pos != 200 {
+		t.Fatalf("checkpoint %+v, want the latest at 200", b.Checkpoint)
+	}
+	if len(b.Events()) != 1 {
+		t.Fatalf("checkpoint added as a row: %+v", b.Events())
+	}
+
+	b.Reset()
+	if !b.Empty() || b.Checkpoint != nil {
+		t.Fatal("Reset left the batch or its checkpoint")
+	}
+}
+
+func TestBatchRetain(t *testing.T) {
+	b := NewBatch()
+	b.Add(rowEvent("u-1", canal.InsertAction, 1))
+	b.Add(rowEvent("u-2", canal.UpdateAction, 1))
+	b.Add(checkpointAt(300))
+
+	// u-2 could not be written, the checkpoint has to wait for it.
+	b.Retain([]SyncEvent{b.Events()[1]})
+	if b.Len() != 1 || b.Events()[0].RowID != "u-2" {
+		t.Fatalf("retained %+v, want u-2", b.Events())
+	}
+	if b.Checkpoint == nil || b.Checkpoint.Position.Pos != 300 {
+		t.Fatalf("checkpoint %+v, want it kept at 300", b.Checkpoint)
+	}
+
+	// A later update of the retained row replaces it, and a later
+	// checkpoint the one held back.
+	b.Add(rowEvent("u-2", canal.UpdateAction, 2))
+	b.Add(checkpointAt(400))
+	if e := b.Events(); len(e) != 1 || e[0].Data["price"] != 2.0 || b.Checkpoint.Position.Pos != 400 {
+		t.Fatalf("after retry: %+v, checkpoint %+v", e, b.Checkpoint)
+	}
+
+	// Everything written, the checkpoint goes with the rows.
+	b.Retain(nil)
+	if !b.Empty() {
+		t.Fatalf("Retain(nil) left %+v, checkpoint %+v", b.Events(), b.Checkpoint)
+	}
+}
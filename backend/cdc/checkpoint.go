@@ -0,0 +1,63 @@
+package cdc
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// CheckpointName identifies this listener's row in cdc_checkpoints.
+const CheckpointName = "product_sync"
+
+// ResolveStart returns the position the listener should start from. A saved
+// checkpoint is used when the binlogs it needs are still on the server;
+// otherwise a fresh position is taken and needsSnapshot is true, meaning the
+// sheet has to be fully rewritten because changes in between are no longer
+// available.
+func ResolveStart() (start database.BinlogCheckpoint, needsSnapshot bool, err error) {
+	saved, err := database.GetCheckpoint(CheckpointName)
+	if err != nil {
+		return start, false, err
+	}
+
+	if saved != nil {
+		resumable, err := canResume(*saved)
+		if err != nil {
+			return start, false, err
+		}
+		if resumable {
+			return *saved, false, nil
+		}
+		log.Printf("Saved checkpoint %s can no longer be resumed, falling back to snapshot", saved)
+	}
+
+	file, pos, gtidSet, err := database.GetMasterStatus()
+	if err != nil {
+		return start, false, err
+	}
+	if GTIDMode() && gtidSet == "" {
+		return start, false, fmt.Errorf("CDC_MODE=gtid but the server reports no executed GTID set, is gtid_mode enabled?")
+	}
+	return database.BinlogCheckpoint{File: file, Pos: pos, GTIDSet: gtidSet}, true, nil
+}
+
+func canResume(cp database.BinlogCheckpoint) (bool, error) {
+	if GTIDMode() {
+		if cp.GTIDSet == "" {
+			// Saved while running in file mode.
+			return false, nil
+		}
+		return database.GTIDSetAvailable(cp.GTIDSet)
+	}
+	return database.BinlogExists(cp.File)
+}
+
+// SaveCheckpoint persists the position carried by a CheckpointAction event.
+func SaveCheckpoint(event SyncEvent) error {
+	return database.SaveCheckpoint(CheckpointName, database.BinlogCheckpoint{
+		File:    event.Position.Name,
+		Pos:     event.Position.Pos,
+		GTIDSet: event.GTIDSet,
+	})
+}
@@ -0,0 +1,98 @@
+package cdc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// decodeRow maps a binlog row image to its column names using the table
+// schema, so adding or reordering columns in MySQL needs no code change.
+func decodeRow(table *schema.Table, row []interface{}) map[string]interface{} {
+	data := make(map[string]interface{}, len(table.Columns))
+	for i := range table.Columns {
+		// The cached schema can be newer than an old row image.
+		if i >= len(row) {
+			break
+		}
+		col := &table.Columns[i]
+		data[col.Name] = decodeValue(col, row[i])
+	}
+	return data
+}
+
+// primaryKey returns the row's primary key as a string. Composite keys are
+// joined with "-".
+func primaryKey(table *schema.Table, row []interface{}) string {
+	var parts []string
+	for _, idx := range table.PKColumns {
+		if idx < len(row) && row[idx] != nil {
+			parts = append(parts, fmt.Sprintf("%v", decodeValue(&table.Columns[idx], row[idx])))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func decodeValue(col *schema.TableColumn, val interface{}) interface{} {
+	if val == nil {
+		return nil
+	}
+
+	switch col.Type {
+	case schema.TYPE_DECIMAL:
+		f, err := strconv.ParseFloat(toString(val), 64)
+		if err != nil {
+			return toString(val)
+		}
+		return f
+
+	case schema.TYPE_NUMBER, schema.TYPE_MEDIUM_INT:
+		if isBoolColumn(col) {
+			n, err := strconv.ParseInt(toString(val), 10, 64)
+			return err == nil && n != 0
+		}
+		return val
+
+	case schema.TYPE_FLOAT:
+		if f, ok := val.(float32); ok {
+			return float64(f)
+		}
+		return val
+
+	case schema.TYPE_TIMESTAMP, schema.TYPE_DATETIME:
+		if t, ok := val.(time.Time); ok {
+			return t.UTC().Format(database.TimeLayout)
+		}
+		return toString(val)
+
+	case schema.TYPE_BINARY:
+		return val
+
+	default:
+		if b, ok := val.([]byte); ok {
+			return string(b)
+		}
+		return val
+	}
+}
+
+// isBoolColumn reports whether the column is MySQL's BOOLEAN, which is stored
+// as TINYINT(1).
+func isBoolColumn(col *schema.TableColumn) bool {
+	return strings.HasPrefix(strings.ToLower(col.RawType), "tinyint(1)")
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
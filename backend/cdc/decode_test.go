@@ -0,0 +1,144 @@
+package cdc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+var productTable = &schema.Table{
+	Schema: "interndb",
+	Name:   "product",
+	Columns: []schema.TableColumn{
+		{Name: "uuid", Type: schema.TYPE_STRING, RawType: "varchar(36)"},
+		{Name: "product_name", Type: schema.TYPE_STRING, RawType: "varchar(255)"},
+		{Name: "quantity", Type: schema.TYPE_NUMBER, RawType: "int"},
+		{Name: "price", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"},
+		{Name: "discount", Type: schema.TYPE_NUMBER, RawType: "tinyint(1)"},
+		{Name: "updated_at", Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"},
+		{Name: "last_updated_by", Type: schema.TYPE_STRING, RawType: "varchar(50)"},
+	},
+	PKColumns: []int{0},
+}
+
+func TestDecodeRow(t *testing.T) {
+	at := time.Date(2026, 1, 1, 10, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		name  string
+		table *schema.Table
+		row   []interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name:  "product row",
+			table: productTable,
+			row:   []interface{}{"u-101", []byte("Widget"), int32(10), "9.99", int8(1), at, "editor@example.com"},
+			want: map[string]interface{}{
+				"uuid": "u-101", "product_name": "Widget", "quantity": int32(10), "price": 9.99,
+				"discount": true, "updated_at": "2026-01-01 09:30:00", "last_updated_by": "editor@example.com",
+			},
+		},
+		{
+			name:  "nulls and false",
+			table: productTable,
+			row:   []interface{}{"u-102", nil, nil, nil, int8(0), nil, nil},
+			want: map[string]interface{}{
+				"uuid": "u-102", "product_name": nil, "quantity": nil, "price": nil,
+				"discount": false, "updated_at": nil, "last_updated_by": nil,
+			},
+		},
+		{
+			name:  "decimal as bytes",
+			table: productTable,
+			row:   []interface{}{"u-103", "Gizmo", int32(0), []byte("1299.50"), int8(0), "2026-01-01 09:00:00", "system"},
+			want: map[string]interface{}{
+				"uuid": "u-103", "product_name": "Gizmo", "quantity": int32(0), "price": 1299.5,
+				"discount": false, "updated_at": "2026-01-01 09:00:00", "last_updated_by": "system",
+			},
+		},
+		{
+			name: "columns reordered and added",
+			table: &schema.Table{
+				Name: "product",
+				Columns: []schema.TableColumn{
+					{Name: "sku", Type: schema.TYPE_STRING, RawType: "varchar(20)"},
+					{Name: "price", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"},
+					{Name: "uuid", Type: schema.TYPE_STRING, RawType: "varchar(36)"},
+					{Name: "stock", Type: schema.TYPE_NUMBER, RawType: "tinyint(4)"},
+				},
+				PKColumns: []int{2},
+			},
+			row:  []interface{}{[]byte("W-1"), "4.25", "u-104", int8(3)},
+			want: map[string]interface{}{"sku": "W-1", "price": 4.25, "uuid": "u-104", "stock": int8(3)},
+		},
+		{
+			name:  "row image older than the schema",
+			table: productTable,
+			row:   []interface{}{"u-105", "Sprocket"},
+			want:  map[string]interface{}{"uuid": "u-105", "product_name": "Sprocket"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeRow(tt.table, tt.row); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeRow() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	composite := &schema.Table{
+		Name: "order_line",
+		Columns: []schema.TableColumn{
+			{Name: "order_id", Type: schema.TYPE_NUMBER, RawType: "int"},
+			{Name: "note", Type: schema.TYPE_STRING, RawType: "text"},
+			{Name: "line", Type: schema.TYPE_NUMBER, RawType: "int"},
+		},
+		PKColumns: []int{0, 2},
+	}
+
+	if got := primaryKey(productTable, []interface{}{[]byte("u-101"), "Widget"}); got != "u-101" {
+		t.Fatalf("primaryKey() = %q, want u-101", got)
+	}
+	if got := primaryKey(composite, []interface{}{int32(7), "gift", int32(2)}); got != "7-2" {
+		t.Fatalf("primaryKey() = %q, want 7-2", got)
+	}
+}
+
+func TestOnRowSendsDecodedRows(t *testing.T) {
+	out := make(chan SyncEvent, 10)
+	h := &MyEventHandler{
+		OutChan: out,
+		Keys:    map[string]string{"product": "uuid"},
+		History: func([]database.Change) error { return nil },
+	}
+
+	before := []interface{}{"u-101", "Widget", int32(10), "9.99", int8(0), nil, "system"}
+	after := []interface{}{"u-101", "Widget", int32(10), "10.99", int8(1), nil, nil}
+	err := h.OnRow(&canal.RowsEvent{Table: productTable, Action: canal.UpdateAction, Rows: [][]interface{}{before, after}})
+	if err != nil {
+		t.Fatalf("OnRow: %v", err)
+	}
+
+	if len(out) != 1 {
+		t.Fatalf("%d events sent, want 1", len(out))
+	}
+	event := <-out
+	if event.RowID != "u-101" || event.Action != canal.UpdateAction || event.Table != "product" {
+		t.Fatalf("event %s %s %s, want product u-101 update", event.Table, event.RowID, event.Action)
+	}
+	if event.Data["price"] != 10.99 || event.Data["discount"] != true {
+		t.Fatalf("sent the before image or undecoded values: %#v", event.Data)
+	}
+	// Rows written without an author are shown as changed by the system.
+	if event.Data["last_updated_by"] != "system" {
+		t.Fatalf("last_updated_by = %#v, want system", event.Data["last_updated_by"])
+	}
+}
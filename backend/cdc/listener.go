@@ -4,24 +4,56 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"strings"
 
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
 	"github.com/go-mysql-org/go-mysql/canal"
 	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
 )
 
+// CheckpointAction marks the end of a transaction whose rows were all sent
+// before it on the channel. Once it has been processed, Position is safe to
+// resume from.
+const CheckpointAction = "checkpoint"
+
+// RefreshAction only writes a row's read-only columns to the sheet. It is
+// sent for rows edited in the sheet, which already shows the edited values
+// and may have newer ones typed since.
+const RefreshAction = "refresh"
+
 type SyncEvent struct {
-	Source string
-	RowID  string
-	Action string
-	Data   map[string]any
+	Source   string
+	Table    string
+	RowID    string
+	Action   string
+	Data     map[string]any
+	Position mysql.Position
+	GTIDSet  string
 }
 
 type MyEventHandler struct {
 	canal.DummyEventHandler
 	OutChan chan<- SyncEvent
+	// Keys maps each synced table to its primary key column.
+	Keys map[string]string
+	// SoftDelete maps tables with soft deletes to the column marking a row
+	// deleted.
+	SoftDelete map[string]string
+	// History stores the change history, database.RecordChanges if nil.
+	History func([]database.Change) error
+
+	// pending is set once a row of the current transaction has been sent.
+	pending bool
+	// origin is the sync_origin marker seen in the current transaction.
+	origin string
+	// binlogFile is the binlog being read, for the position of changes.
+	binlogFile string
 }
 
-func StartListener(outChan chan<- SyncEvent, startFile string, startPos uint32) {
+func StartListener(outChan chan<- SyncEvent, start database.BinlogCheckpoint, mappings []config.TableMapping) {
 	cfg := canal.NewDefaultConfig()
 
 	dbHost := os.Getenv("DB_HOST")
@@ -35,71 +67,171 @@ func StartListener(outChan chan<- SyncEvent, startFile string, startPos uint32)
 	cfg.User = "replicator"
 	cfg.Password = "password"
 	cfg.Dump.ExecutionPath = ""
-	cfg.IncludeTableRegex = []string{"interndb\\.product"}
+	cfg.ParseTime = true
+	cfg.IncludeTableRegex = []string{"^interndb\\.sync_origin$"}
+
+	keys := map[string]string{}
+	softDelete := map[string]string{}
+	for _, m := range mappings {
+		cfg.IncludeTableRegex = append(cfg.IncludeTableRegex, "^interndb\\."+regexp.QuoteMeta(m.Table)+"$")
+		keys[m.Table] = m.PrimaryKey
+		if m.SoftDelete != "" {
+			softDelete[m.Table] = m.SoftDelete
+		}
+	}
 
 	c, err := canal.NewCanal(cfg)
 	if err != nil {
 		log.Fatalf("CDC Setup Error: %v", err)
 	}
 
-	c.SetEventHandler(&MyEventHandler{OutChan: outChan})
+	c.SetEventHandler(&MyEventHandler{OutChan: outChan, Keys: keys, SoftDelete: softDelete})
+
+	if GTIDMode() {
+		gtidSet, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, start.GTIDSet)
+		if err != nil {
+			log.Fatalf("CDC Setup Error: invalid GTID set %q: %v", start.GTIDSet, err)
+		}
+
+		log.Printf("CDC Listener starting from GTID set: %s", start.GTIDSet)
+
+		if err := c.StartFromGTID(gtidSet); err != nil {
+			log.Fatalf("CDC Run Error: %v", err)
+		}
+		return
+	}
 
 	pos := mysql.Position{
-		Name: startFile,
-		Pos:  startPos,
+		Name: start.File,
+		Pos:  start.Pos,
 	}
 
-	log.Printf("CDC Listener starting from: %s : %d", startFile, startPos)
+	log.Printf("CDC Listener starting from: %s : %d", start.File, start.Pos)
 
 	if err := c.RunFrom(pos); err != nil {
 		log.Fatalf("CDC Run Error: %v", err)
 	}
 }
 
+// GTIDMode reports whether the listener tracks its position by GTID set
+// (CDC_MODE=gtid) instead of binlog file and offset. GTID positions survive a
+// failover to a replica whose binlog files are named differently.
+func GTIDMode() bool {
+	return strings.EqualFold(os.Getenv("CDC_MODE"), "gtid")
+}
+
 func (h *MyEventHandler) OnRow(e *canal.RowsEvent) error {
-	if e.Action == canal.UpdateAction || e.Action == canal.InsertAction || e.Action == canal.DeleteAction {
+	if e.Action != canal.UpdateAction && e.Action != canal.InsertAction && e.Action != canal.DeleteAction {
+		return nil
+	}
 
-		row := e.Rows[len(e.Rows)-1]
+	// Update events carry [before, after] pairs, only the after image is
+	// synced. The before image is compared with it for the change history.
+	first, step := 0, 1
+	if e.Action == canal.UpdateAction {
+		first, step = 1, 2
+	}
 
-		lastUpdatedBy := "system"
-		if len(row) > 6 {
-			if val, ok := row[6].(string); ok {
-				lastUpdatedBy = val
-			} else if valBytes, ok := row[6].([]byte); ok {
-				lastUpdatedBy = string(valBytes)
-			}
+	if e.Table.Name == "sync_origin" {
+		if len(e.Rows) > 0 {
+			h.origin, _ = decodeRow(e.Table, e.Rows[len(e.Rows)-1])["origin"].(string)
 		}
+		return nil
+	}
+
+	var changes []database.Change
+	for i := first; i < len(e.Rows); i += step {
+		row := e.Rows[i]
+		data := decodeRow(e.Table, row)
 
-		if lastUpdatedBy == "sync_bot" {
-			return nil
+		rowID := primaryKey(e.Table, row)
+		if key, ok := h.Keys[e.Table.Name]; ok && data[key] != nil {
+			rowID = fmt.Sprintf("%v", data[key])
 		}
 
-		uuid := ""
-		if len(row) > 0 {
-			if val, ok := row[0].(string); ok {
-				uuid = val
-			} else if valBytes, ok := row[0].([]byte); ok {
-				uuid = string(valBytes)
-			}
+		var before map[string]any
+		if e.Action == canal.UpdateAction {
+			before = decodeRow(e.Table, e.Rows[i-1])
 		}
+		changes = append(changes, h.rowChanges(e, rowID, before, data)...)
 
-		data := map[string]interface{}{}
+		// The sheet already removed rows deleted there.
+		if h.origin == database.OriginSheet && e.Action == canal.DeleteAction {
+			continue
+		}
 
-		if len(row) > 4 {
-			data["uuid"] = uuid
-			data["product_name"] = row[1]
-			data["quantity"] = row[2]
-			data["price"] = row[3]
-			data["discount"] = row[4]
-			data["last_updated_by"] = lastUpdatedBy
+		lastUpdatedBy, ok := data["last_updated_by"].(string)
+		if !ok || lastUpdatedBy == "" {
+			lastUpdatedBy = "system"
+		}
+		data["last_updated_by"] = lastUpdatedBy
+
+		// A soft-deleted row leaves the sheet, a restored one is an
+		// ordinary update and gets appended again.
+		action := e.Action
+		if col, ok := h.SoftDelete[e.Table.Name]; ok && data[col] != nil {
+			if h.origin == database.OriginSheet {
+				continue
+			}
+			action = canal.DeleteAction
+		} else if h.origin == database.OriginSheet {
+			// The sheet shows its own edits, only who made them and
+			// when is new to it.
+			action = RefreshAction
 		}
 
 		h.OutChan <- SyncEvent{
 			Source: "MYSQL",
-			RowID:  uuid,
-			Action: e.Action,
+			Table:  e.Table.Name,
+			RowID:  rowID,
+			Action: action,
 			Data:   data,
 		}
+		h.pending = true
+	}
+
+	record := h.History
+	if record == nil {
+		record = database.RecordChanges
+	}
+	// Losing history is better than stalling the sync.
+	if err := record(changes); err != nil {
+		log.Printf("CDC Error: %v", err)
 	}
 	return nil
 }
+
+// OnRotate is called when the listener moves on to the next binlog file,
+// and once at the start with the file it starts in.
+func (h *MyEventHandler) OnRotate(header *replication.EventHeader, e *replication.RotateEvent) error {
+	h.binlogFile = string(e.NextLogName)
+	return nil
+}
+
+// OnXID ends the transaction, so its origin marker no longer applies.
+func (h *MyEventHandler) OnXID(header *replication.EventHeader, nextPos mysql.Position) error {
+	h.origin = ""
+	return nil
+}
+
+// OnPosSynced is called by canal at transaction boundaries (and on rotate).
+// Transactions that produced no events are not checkpointed, otherwise our
+// own checkpoint writes would keep generating new checkpoints.
+func (h *MyEventHandler) OnPosSynced(header *replication.EventHeader, pos mysql.Position, set mysql.GTIDSet, force bool) error {
+	if !h.pending && !force {
+		return nil
+	}
+	h.pending = false
+
+	event := SyncEvent{
+		Source:   "MYSQL",
+		Action:   CheckpointAction,
+		Position: pos,
+	}
+	if set != nil {
+		event.GTIDSet = set.String()
+	}
+
+	h.OutChan <- event
+	return nil
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Coerce converts a value edited in the sheet to the column's database type
+// and checks it against the column's limits. Values that can't be stored
+// as they are, rather than silently changed by MySQL, are an error.
+func (c Column) Coerce(val interface{}) (interface{}, error) {
+	switch c.Type {
+	case TypeInt:
+		f, err := toNumber(val)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("%s is not a whole number", formatNumber(f))
+		}
+		min, max := float64(math.MinInt32), float64(math.MaxInt32)
+		if err := c.checkRange(f, &min, &max); err != nil {
+			return nil, err
+		}
+		return int(f), nil
+
+	case TypeDecimal:
+		f, err := toNumber(val)
+		if err != nil {
+			return nil, err
+		}
+		if c.Scale > 0 || c.Precision > 0 {
+			unit := math.Pow10(c.Scale)
+			rounded := math.Round(f*unit) / unit
+			// Allow for float noise such as 0.1+0.2, not for real extra digits.
+			if math.Abs(rounded-f) > 1e-9 {
+				return nil, fmt.Errorf("%s has more than %d decimal places", formatNumber(f), c.Scale)
+			}
+			f = rounded
+		}
+		if c.Precision > 0 && math.Abs(f) >= math.Pow10(c.Precision-c.Scale) {
+			return nil, fmt.Errorf("%s does not fit in DECIMAL(%d,%d)", formatNumber(f), c.Precision, c.Scale)
+		}
+		if err := c.checkRange(f, nil, nil); err != nil {
+			return nil, err
+		}
+		return f, nil
+
+	case TypeBool:
+		switch v := val.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "y", "1", "on", "✓", "✔", "☑", "x":
+				return true, nil
+			case "false", "no", "n", "0", "off", "✗", "✘", "☐", "":
+				return false, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not yes/no", fmt.Sprintf("%v", val))
+
+	default:
+		var str string
+		switch v := val.(type) {
+		case nil:
+		case string:
+			str = v
+		case float64:
+			str = formatNumber(v)
+		default:
+			str = fmt.Sprintf("%v", v)
+		}
+		if c.MaxLength > 0 && utf8.RuneCountInString(str) > c.MaxLength {
+			return nil, fmt.Errorf("longer than %d characters", c.MaxLength)
+		}
+		return str, nil
+	}
+}
+
+// checkRange applies the column's Min and Max, falling back to the given
+// defaults when they are not set.
+func (c Column) checkRange(f float64, min, max *float64) error {
+	if c.Min != nil {
+		min = c.Min
+	}
+	if c.Max != nil {
+		max = c.Max
+	}
+	if min != nil && f < *min {
+		return fmt.Errorf("%s is below the minimum of %s", formatNumber(f), formatNumber(*min))
+	}
+	if max != nil && f > *max {
+		return fmt.Errorf("%s is above the maximum of %s", formatNumber(f), formatNumber(*max))
+	}
+	return nil
+}
+
+// groupedNumber is a number with "," grouping and "." decimals, after the
+// sheet's locale has been mapped onto those.
+var groupedNumber = regexp.MustCompile(`^[+-]?(\d{1,3}(,\d{3})+|\d+)(\.\d+)?$|^[+-]?\.\d+$`)
+
+// toNumber reads a number edited in the sheet. Numbers usually arrive as
+// float64; text such as "1,299.00" or "$ 49.99" (or "1.299,00" with a
+// decimal comma SHEET_LOCALE) is parsed by hand.
+func toNumber(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		return 0, fmt.Errorf("%v is not a number", v)
+	case nil:
+		return 0, fmt.Errorf("a number is required")
+	}
+
+	raw := strings.TrimSpace(fmt.Sprintf("%v", val))
+	if raw == "" {
+		return 0, fmt.Errorf("a number is required")
+	}
+
+	s := raw
+	if decimalComma() {
+		s = strings.Map(func(r rune) rune {
+			switch r {
+			case ',':
+				return '.'
+			case '.':
+				return ','
+			}
+			return r
+		}, s)
+	}
+
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '$', '€', '£', '¥':
+			return -1
+		case ' ', '\u00a0', '\u202f', '\'':
+			return ',' // thousands separators in many locales
+		}
+		return r
+	}, s)
+	s = strings.Trim(s, ",")
+
+	if !groupedNumber.MatchString(s) {
+		return 0, fmt.Errorf("%q is not a number", raw)
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", raw)
+	}
+	return f, nil
+}
+
+// decimalComma reports whether SHEET_LOCALE (e.g. "de_DE") writes decimals
+// with a comma. The default is "en_US".
+func decimalComma() bool {
+	lang := strings.ToLower(os.Getenv("SHEET_LOCALE"))
+	if i := strings.IndexAny(lang, "_-"); i >= 0 {
+		lang = lang[:i]
+	}
+	switch lang {
+	case "de", "fr", "es", "it", "pt", "nl", "ru", "pl", "tr", "sv", "da", "nb", "no", "fi", "cs", "id", "vi":
+		return true
+	}
+	return false
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// SheetValue converts a database value to what is written into the sheet.
+func (c Column) SheetValue(val interface{}) interface{} {
+	if val == nil {
+		return ""
+	}
+
+	switch c.Type {
+	case TypeInt:
+		switch v := val.(type) {
+		case float64:
+			return int64(v)
+		case bool:
+			if v {
+				return 1
+			}
+			return 0
+		}
+		return val
+	case TypeDecimal:
+		if s, ok := val.(string); ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+		}
+		return val
+	case TypeBool:
+		switch v := val.(type) {
+		case bool:
+			return v
+		case string:
+			return v == "1" || v == "true" || v == "TRUE"
+		default:
+			return fmt.Sprintf("%v", v) != "0"
+		}
+	case TypeString, TypeTimestamp:
+		return fmt.Sprintf("%v", val)
+	}
+	return val
+}
@@ -0,0 +1,107 @@
+package config
+
+import "testing"
+
+func TestCoerce(t *testing.T) {
+	zero := 0.0
+	intCol := Column{Name: "quantity", Type: TypeInt, Min: &zero}
+	priceCol := Column{Name: "price", Type: TypeDecimal, Min: &zero, Precision: 10, Scale: 2}
+	boolCol := Column{Name: "discount", Type: TypeBool}
+	nameCol := Column{Name: "product_name", Type: TypeString, MaxLength: 5}
+
+	tests := []struct {
+		name    string
+		col     Column
+		locale  string
+		in      interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		// Whole numbers.
+		{name: "int from number", col: intCol, in: 3.0, want: 3},
+		{name: "int from text", col: intCol, in: "42", want: 42},
+		{name: "int with grouping", col: intCol, in: "1,000", want: 1000},
+		{name: "int with fraction", col: intCol, in: 2.5, wantErr: true},
+		{name: "int below min", col: intCol, in: -1.0, wantErr: true},
+		{name: "int above int32", col: intCol, in: 3e9, wantErr: true},
+		{name: "int from bool", col: intCol, in: true, wantErr: true},
+		{name: "int blank", col: intCol, in: "", wantErr: true},
+		{name: "int missing", col: intCol, in: nil, wantErr: true},
+
+		// Decimals, en_US.
+		{name: "decimal from number", col: priceCol, in: 12.5, want: 12.5},
+		{name: "decimal with grouping", col: priceCol, in: "1,299.00", want: 1299.0},
+		{name: "decimal with currency", col: priceCol, in: "$ 49.99", want: 49.99},
+		{name: "decimal with euro sign", col: priceCol, in: "€12.50", want: 12.5},
+		{name: "decimal space grouping", col: priceCol, in: "1 299.5", want: 1299.5},
+		{name: "decimal no-break space grouping", col: priceCol, in: "1\u00a0299", want: 1299.0},
+		{name: "decimal apostrophe grouping", col: priceCol, in: "1'299.50", want: 1299.5},
+		{name: "decimal leading point", col: priceCol, in: ".5", want: 0.5},
+		{name: "decimal with plus", col: priceCol, in: "+7", want: 7.0},
+		{name: "decimal float noise rounded away", col: priceCol, in: 0.1 + 0.2, want: 0.3},
+		{name: "decimal trailing zero", col: priceCol, in: "19.90", want: 19.9},
+		{name: "decimal too many places", col: priceCol, in: "1.234", wantErr: true},
+		{name: "decimal largest fitting", col: priceCol, in: 99999999.99, want: 99999999.99},
+		{name: "decimal overflows precision", col: priceCol, in: 1e8, wantErr: true},
+		{name: "decimal below min", col: priceCol, in: "-0.01", wantErr: true},
+		{name: "decimal bad grouping", col: priceCol, in: "1,2345", wantErr: true},
+		{name: "decimal comma in en_US", col: priceCol, in: "12,34", wantErr: true},
+		{name: "decimal two points", col: priceCol, in: "1.2.3", wantErr: true},
+		{name: "decimal text", col: priceCol, in: "abc", wantErr: true},
+		{name: "decimal blank", col: priceCol, in: "  ", wantErr: true},
+
+		// Decimals, with a decimal comma.
+		{name: "de grouping", col: priceCol, locale: "de_DE", in: "1.299,00", want: 1299.0},
+		{name: "de decimal comma", col: priceCol, locale: "de_DE", in: "49,99", want: 49.99},
+		{name: "fr space grouping", col: priceCol, locale: "fr-FR", in: "1 299,5", want: 1299.5},
+		{name: "de number unaffected", col: priceCol, locale: "de_DE", in: 12.5, want: 12.5},
+		{name: "de en_US text", col: priceCol, locale: "de_DE", in: "1,299.00", wantErr: true},
+		{name: "en locale explicit", col: priceCol, locale: "en_GB", in: "1,299.00", want: 1299.0},
+
+		// Booleans.
+		{name: "bool true", col: boolCol, in: true, want: true},
+		{name: "bool false", col: boolCol, in: false, want: false},
+		{name: "bool one", col: boolCol, in: 1.0, want: true},
+		{name: "bool zero", col: boolCol, in: 0.0, want: false},
+		{name: "bool two", col: boolCol, in: 2.0, wantErr: true},
+		{name: "bool yes", col: boolCol, in: "Yes", want: true},
+		{name: "bool y", col: boolCol, in: "y", want: true},
+		{name: "bool on", col: boolCol, in: " ON ", want: true},
+		{name: "bool text one", col: boolCol, in: "1", want: true},
+		{name: "bool check mark", col: boolCol, in: "✓", want: true},
+		{name: "bool x", col: boolCol, in: "X", want: true},
+		{name: "bool no", col: boolCol, in: "No", want: false},
+		{name: "bool off", col: boolCol, in: "off", want: false},
+		{name: "bool empty box", col: boolCol, in: "☐", want: false},
+		{name: "bool blank", col: boolCol, in: "", want: false},
+		{name: "bool maybe", col: boolCol, in: "maybe", wantErr: true},
+		{name: "bool missing", col: boolCol, in: nil, wantErr: true},
+
+		// Strings.
+		{name: "string", col: nameCol, in: "Gizmo", want: "Gizmo"},
+		{name: "string counts runes", col: nameCol, in: "héllo", want: "héllo"},
+		{name: "string too long", col: nameCol, in: "Gadget", wantErr: true},
+		{name: "string from number", col: nameCol, in: 12.5, want: "12.5"},
+		{name: "string missing", col: nameCol, in: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SHEET_LOCALE", tt.locale)
+
+			got, err := tt.col.Coerce(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Coerce(%#v) = %#v, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Coerce(%#v): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Fatalf("Coerce(%#v) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvInt reads a positive integer setting, fallback if it is unset or
+// invalid.
+func EnvInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// EnvDuration reads a positive duration setting such as "30s", fallback if
+// it is unset or invalid.
+func EnvDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
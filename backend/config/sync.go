@@ -0,0 +1,247 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+)
+
+// Column maps a database column to a sheet column. Type decides how values
+// are written to the sheet and how edits coming back from it are coerced;
+// read-only columns are never written from the sheet or the API.
+//
+// Edits are validated against Min/Max (int and decimal; ints default to the
+// range of INT), Precision/Scale (decimal, as in DECIMAL(10,2)) and
+// MaxLength (string).
+type Column struct {
+	Name         string        `json:"name"`
+	Header       string        `json:"header"`
+	Type         string        `json:"type"`
+	NumberFormat *NumberFormat `json:"number_format,omitempty"`
+	Align        string        `json:"align,omitempty"`
+	ReadOnly     bool          `json:"read_only,omitempty"`
+	Min          *float64      `json:"min,omitempty"`
+	Max          *float64      `json:"max,omitempty"`
+	Precision    int           `json:"precision,omitempty"`
+	Scale        int           `json:"scale,omitempty"`
+	MaxLength    int           `json:"max_length,omitempty"`
+}
+
+// NumberFormat is a Sheets number format, e.g. {"type": "CURRENCY",
+// "pattern": "$#,##0.00"}.
+type NumberFormat struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+}
+
+// Column types.
+const (
+	TypeString    = "string"
+	TypeInt       = "int"
+	TypeDecimal   = "decimal"
+	TypeBool      = "bool"
+	TypeTimestamp = "timestamp"
+)
+
+var columnTypes = map[string]bool{
+	TypeString:    true,
+	TypeInt:       true,
+	TypeDecimal:   true,
+	TypeBool:      true,
+	TypeTimestamp: true,
+}
+
+// TableMapping declares a MySQL table that is kept in sync with its own tab.
+// Columns are laid out left to right in the order given and the first one
+// must be the primary key, which identifies the row in the sheet.
+//
+// SoftDelete optionally names a nullable timestamp column that is set instead
+// of deleting a row. Rows where it is set count as deleted: they are left out
+// of the tab and can be restored by clearing it.
+type TableMapping struct {
+	Table      string   `json:"table"`
+	PrimaryKey string   `json:"primary_key"`
+	Tab        string   `json:"tab"`
+	Columns    []Column `json:"columns"`
+	SoftDelete string   `json:"soft_delete,omitempty"`
+}
+
+type syncFile struct {
+	Tables []TableMapping `json:"tables"`
+}
+
+// TableMappings is the list loaded by LoadTableMappings.
+var TableMappings []TableMapping
+
+// defaultMappings is used when no sync config file is present and matches
+// the original single-table layout.
+var defaultMappings = []TableMapping{
+	{
+		Table:      "product",
+		PrimaryKey: "uuid",
+		Tab:        "Sheet1",
+		SoftDelete: "deleted_at",
+		Columns: []Column{
+			{Name: "uuid", Header: "UUID", Type: TypeString, ReadOnly: true},
+			{Name: "product_name", Header: "Product Name", Type: TypeString, MaxLength: 255},
+			{Name: "quantity", Header: "Quantity", Type: TypeInt, Align: "CENTER", Min: &zero},
+			{Name: "price", Header: "Price", Type: TypeDecimal, NumberFormat: &NumberFormat{Type: "CURRENCY", Pattern: "$#,##0.00"}, Min: &zero, Precision: 10, Scale: 2},
+			{Name: "discount", Header: "Discount", Type: TypeBool},
+			{Name: "updated_at", Header: "Last Updated", Type: TypeTimestamp, ReadOnly: true},
+			{Name: "last_updated_by", Header: "Updated By", Type: TypeString, ReadOnly: true},
+			{Name: "version", Header: "Version", Type: TypeInt, Align: "CENTER", ReadOnly: true},
+		},
+	},
+}
+
+var zero = 0.0
+
+var identifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// LoadTableMappings reads the table to tab mappings from the JSON file named
+// by SYNC_CONFIG (default "sync.json").
+func LoadTableMappings() error {
+	path := os.Getenv("SYNC_CONFIG")
+	if path == "" {
+		path = "sync.json"
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("No sync config at %s, syncing the default product table", path)
+		TableMappings = defaultMappings
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sync config: %w", err)
+	}
+
+	var file syncFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("failed to parse sync config %s: %w", path, err)
+	}
+
+	if err := ValidateMappings(file.Tables); err != nil {
+		return fmt.Errorf("invalid sync config %s: %w", path, err)
+	}
+
+	TableMappings = file.Tables
+	return nil
+}
+
+// ValidateMappings checks that every mapping is complete and that table and
+// column names are plain identifiers, since they end up in SQL statements.
+// Columns without a type default to string.
+func ValidateMappings(mappings []TableMapping) error {
+	if len(mappings) == 0 {
+		return errors.New("no tables declared")
+	}
+
+	tabs := map[string]bool{}
+	tables := map[string]bool{}
+
+	for _, m := range mappings {
+		if !identifier.MatchString(m.Table) {
+			return fmt.Errorf("invalid table name %q", m.Table)
+		}
+		if m.Tab == "" {
+			return fmt.Errorf("table %s: tab is required", m.Table)
+		}
+		if m.SoftDelete != "" && !identifier.MatchString(m.SoftDelete) {
+			return fmt.Errorf("table %s: invalid soft delete column %q", m.Table, m.SoftDelete)
+		}
+		if tabs[m.Tab] || tables[m.Table] {
+			return fmt.Errorf("table %s: table and tab must be unique", m.Table)
+		}
+		tabs[m.Tab] = true
+		tables[m.Table] = true
+
+		if len(m.Columns) == 0 || m.Columns[0].Name != m.PrimaryKey {
+			return fmt.Errorf("table %s: first column must be the primary key %q", m.Table, m.PrimaryKey)
+		}
+		headers := map[string]bool{}
+		for i := range m.Columns {
+			c := &m.Columns[i]
+			if !identifier.MatchString(c.Name) {
+				return fmt.Errorf("table %s: invalid column name %q", m.Table, c.Name)
+			}
+			if c.Header == "" || headers[c.Header] {
+				return fmt.Errorf("table %s: column %s needs a unique header", m.Table, c.Name)
+			}
+			headers[c.Header] = true
+
+			if c.Type == "" {
+				c.Type = TypeString
+			}
+			if !columnTypes[c.Type] {
+				return fmt.Errorf("table %s: column %s has unknown type %q", m.Table, c.Name, c.Type)
+			}
+			if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+				return fmt.Errorf("table %s: column %s has min above max", m.Table, c.Name)
+			}
+			if c.Scale < 0 || c.Precision < 0 || (c.Precision > 0 && c.Scale > c.Precision) {
+				return fmt.Errorf("table %s: column %s has an invalid precision/scale", m.Table, c.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// ColumnNames returns the database columns in sheet order.
+func (m TableMapping) ColumnNames() []string {
+	names := make([]string, len(m.Columns))
+	for i, c := range m.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Column returns the column with the given database name.
+func (m TableMapping) Column(name string) (Column, bool) {
+	for _, c := range m.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// ColumnByHeader returns the column shown under the given sheet header.
+func (m TableMapping) ColumnByHeader(header string) (Column, bool) {
+	for _, c := range m.Columns {
+		if c.Header == header {
+			return c, true
+		}
+	}
+	return Column{}, false
+}
+
+// Writable reports whether a column may be changed from the sheet or the
+// API. The primary key and read-only columns may not.
+func (m TableMapping) Writable(name string) bool {
+	c, ok := m.Column(name)
+	return ok && !c.ReadOnly && c.Name != m.PrimaryKey
+}
+
+// MappingForTable returns the loaded mapping of a table.
+func MappingForTable(table string) (TableMapping, bool) {
+	for _, m := range TableMappings {
+		if m.Table == table {
+			return m, true
+		}
+	}
+	return TableMapping{}, false
+}
+
+// MappingForTab returns the loaded mapping shown in a tab.
+func MappingForTab(tab string) (TableMapping, bool) {
+	for _, m := range TableMappings {
+		if m.Tab == tab {
+			return m, true
+		}
+	}
+	return TableMapping{}, false
+}
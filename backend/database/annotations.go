@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// AnnotatedCell identifies a sheet cell by the row and column it shows.
+type AnnotatedCell struct {
+	Table  string
+	RowID  string
+	Column string
+}
+
+// AnnotationStore remembers which cells the sync marked with a note and a
+// colour, so clearing an annotation never touches what a user put on a cell.
+type AnnotationStore interface {
+	// Annotated returns which of cells are marked.
+	Annotated(cells []AnnotatedCell) (map[AnnotatedCell]bool, error)
+	// SetAnnotated marks or unmarks cells.
+	SetAnnotated(cells []AnnotatedCell, annotated bool) error
+}
+
+// MySQLAnnotations is the AnnotationStore kept in sheet_annotations.
+type MySQLAnnotations struct{}
+
+func (MySQLAnnotations) Annotated(cells []AnnotatedCell) (map[AnnotatedCell]bool, error) {
+	marked := map[AnnotatedCell]bool{}
+	if len(cells) == 0 {
+		return marked, nil
+	}
+
+	var conds []string
+	var args []interface{}
+	for _, c := range cells {
+		conds = append(conds, "(table_name = ? AND row_id = ? AND column_name = ?)")
+		args = append(args, c.Table, c.RowID, c.Column)
+	}
+	query := "SELECT table_name, row_id, column_name FROM sheet_annotations WHERE " + strings.Join(conds, " OR ")
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotated cells: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c AnnotatedCell
+		if err := rows.Scan(&c.Table, &c.RowID, &c.Column); err != nil {
+			return nil, err
+		}
+		marked[c] = true
+	}
+	return marked, rows.Err()
+}
+
+func (MySQLAnnotations) SetAnnotated(cells []AnnotatedCell, annotated bool) error {
+	if len(cells) == 0 {
+		return nil
+	}
+
+	var args []interface{}
+	for _, c := range cells {
+		args = append(args, c.Table, c.RowID, c.Column)
+	}
+
+	var query string
+	if annotated {
+		values := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(cells)), ", ")
+		query = "INSERT IGNORE INTO sheet_annotations (table_name, row_id, column_name) VALUES " + values
+	} else {
+		conds := strings.TrimSuffix(strings.Repeat("(table_name = ? AND row_id = ? AND column_name = ?) OR ", len(cells)), " OR ")
+		query = "DELETE FROM sheet_annotations WHERE " + conds
+	}
+
+	if _, err := DB.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to record annotated cells: %w", err)
+	}
+	return nil
+}
+
+// MemoryAnnotations is an AnnotationStore kept in memory, for tests.
+type MemoryAnnotations struct {
+	mu     sync.Mutex
+	marked map[AnnotatedCell]bool
+}
+
+func NewMemoryAnnotations() *MemoryAnnotations {
+	return &MemoryAnnotations{marked: map[AnnotatedCell]bool{}}
+}
+
+func (m *MemoryAnnotations) Annotated(cells []AnnotatedCell) (map[AnnotatedCell]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	marked := map[AnnotatedCell]bool{}
+	for _, c := range cells {
+		if m.marked[c] {
+			marked[c] = true
+		}
+	}
+	return marked, nil
+}
+
+func (m *MemoryAnnotations) SetAnnotated(cells []AnnotatedCell, annotated bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range cells {
+		if annotated {
+			m.marked[c] = true
+		} else {
+			delete(m.marked, c)
+		}
+	}
+	return nil
+}
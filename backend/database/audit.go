@@ -0,0 +1,77 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Change is one field of one row changed by one binlog event. Inserts have
+// no OldValue and deletes no NewValue; nil means NULL.
+type Change struct {
+	ID         int64     `json:"id"`
+	Table      string    `json:"table"`
+	RowID      string    `json:"row_id"`
+	Action     string    `json:"action"`
+	Field      string    `json:"field"`
+	OldValue   *string   `json:"old_value"`
+	NewValue   *string   `json:"new_value"`
+	Author     string    `json:"author"`
+	Origin     string    `json:"origin"`
+	BinlogFile string    `json:"binlog_file"`
+	BinlogPos  uint32    `json:"binlog_pos"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// RecordChanges stores changes in change_history. A binlog event read again
+// after a restart is ignored, the position and row identify a change.
+func RecordChanges(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var rows []string
+	var args []interface{}
+	for _, c := range changes {
+		rows = append(rows, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, c.Table, c.RowID, c.Action, c.Field, c.OldValue, c.NewValue,
+			c.Author, c.Origin, c.BinlogFile, c.BinlogPos, c.ChangedAt)
+	}
+
+	query := `
+		INSERT IGNORE INTO change_history (table_name, row_id, action, field, old_value, new_value,
+			author, origin, binlog_file, binlog_pos, changed_at)
+		VALUES ` + strings.Join(rows, ", ")
+	if _, err := DB.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to record %d changes: %w", len(changes), err)
+	}
+	return nil
+}
+
+// ListChanges returns the most recent changes of a row, newest first.
+func ListChanges(table, rowID string, limit int) ([]Change, error) {
+	query := `
+		SELECT id, table_name, row_id, action, field, old_value, new_value,
+			author, origin, binlog_file, binlog_pos, changed_at
+		FROM change_history
+		WHERE table_name = ? AND row_id = ?
+		ORDER BY changed_at DESC, id DESC
+		LIMIT ?
+	`
+	rows, err := DB.Query(query, table, rowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Change
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.ID, &c.Table, &c.RowID, &c.Action, &c.Field, &c.OldValue, &c.NewValue,
+			&c.Author, &c.Origin, &c.BinlogFile, &c.BinlogPos, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// BinlogCheckpoint is the last binlog position whose row changes have been
+// fully written to the sheet.
+type BinlogCheckpoint struct {
+	File    string
+	Pos     uint32
+	GTIDSet string
+}
+
+func (cp BinlogCheckpoint) String() string {
+	if cp.GTIDSet != "" {
+		return cp.GTIDSet
+	}
+	return fmt.Sprintf("%s:%d", cp.File, cp.Pos)
+}
+
+func SaveCheckpoint(name string, cp BinlogCheckpoint) error {
+	query := `
+		INSERT INTO cdc_checkpoints (name, binlog_file, binlog_pos, gtid_set)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			binlog_file = VALUES(binlog_file),
+			binlog_pos = VALUES(binlog_pos),
+			gtid_set = VALUES(gtid_set)
+	`
+	_, err := DB.Exec(query, name, cp.File, cp.Pos, cp.GTIDSet)
+	if err != nil {
+		return fmt.Errorf("failed to store checkpoint: %w", err)
+	}
+	return nil
+}
+
+// GetCheckpoint returns nil without an error when nothing has been saved yet.
+func GetCheckpoint(name string) (*BinlogCheckpoint, error) {
+	var cp BinlogCheckpoint
+	var gtidSet sql.NullString
+
+	query := "SELECT binlog_file, binlog_pos, gtid_set FROM cdc_checkpoints WHERE name = ?"
+	err := DB.QueryRow(query, name).Scan(&cp.File, &cp.Pos, &gtidSet)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cp.GTIDSet = gtidSet.String
+	return &cp, nil
+}
+
+// BinlogExists reports whether the server still has the given binlog file,
+// i.e. it has not been purged since the checkpoint was taken.
+func BinlogExists(file string) (bool, error) {
+	rows, err := DB.Query("SHOW BINARY LOGS")
+	if err != nil {
+		return false, fmt.Errorf("failed to list binary logs: %v", err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+
+	for rows.Next() {
+		// Column count differs between versions (8.0 adds "Encrypted"),
+		// only the first one, Log_name, matters here.
+		var logName string
+		dest := make([]interface{}, len(cols))
+		dest[0] = &logName
+		for i := 1; i < len(dest); i++ {
+			dest[i] = new(sql.RawBytes)
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return false, err
+		}
+		if logName == file {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// GTIDSetAvailable reports whether every transaction after the given GTID set
+// can still be read from the binlogs, i.e. nothing it lacks has been purged.
+func GTIDSetAvailable(gtidSet string) (bool, error) {
+	var available bool
+	err := DB.QueryRow("SELECT GTID_SUBSET(@@GLOBAL.gtid_purged, ?)", gtidSet).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("failed to check purged GTIDs: %v", err)
+	}
+	return available, nil
+}
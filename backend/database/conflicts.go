@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// VersionColumn is bumped by a trigger on every change to a row.
+const VersionColumn = "version"
+
+// Where a write came from, as recorded with conflicts.
+const (
+	SourceSheet = OriginSheet
+	SourceAPI   = OriginAPI
+)
+
+// Conflict resolution policies (CONFLICT_POLICY).
+const (
+	ConflictReject        = "reject"
+	ConflictLastWriteWins = "last_write_wins"
+	ConflictSheetWins     = "sheet_wins"
+	ConflictAPIWins       = "api_wins"
+)
+
+// Conflict resolutions.
+const (
+	ConflictApplied  = "applied"
+	ConflictRejected = "rejected"
+)
+
+// Conflict is a write based on an older version of a row than the current
+// one, with the value it tried to set and the value it would overwrite.
+type Conflict struct {
+	ID             int64     `json:"id"`
+	Table          string    `json:"table"`
+	RowID          string    `json:"row_id"`
+	Field          string    `json:"field"`
+	Source         string    `json:"source"`
+	BaseVersion    int64     `json:"base_version"`
+	CurrentVersion int64     `json:"current_version"`
+	AttemptedValue string    `json:"attempted_value"`
+	AttemptedBy    string    `json:"attempted_by"`
+	CurrentValue   string    `json:"current_value"`
+	CurrentBy      string    `json:"current_by"`
+	Resolution     string    `json:"resolution"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ConflictPolicy returns the configured CONFLICT_POLICY, "reject" by default.
+func ConflictPolicy() string {
+	switch p := os.Getenv("CONFLICT_POLICY"); p {
+	case ConflictLastWriteWins, ConflictSheetWins, ConflictAPIWins:
+		return p
+	default:
+		return ConflictReject
+	}
+}
+
+// ConflictWins reports whether a conflicting write from source is applied
+// under policy.
+func ConflictWins(policy, source string) bool {
+	switch policy {
+	case ConflictLastWriteWins:
+		return true
+	case ConflictSheetWins:
+		return source == SourceSheet
+	case ConflictAPIWins:
+		return source == SourceAPI
+	default:
+		return false
+	}
+}
+
+// LogConflict records a conflict whose transaction was rolled back.
+func LogConflict(c Conflict) error {
+	return logConflict(DB, c)
+}
+
+func logConflict(e execer, c Conflict) error {
+	query := `
+		INSERT INTO sync_conflicts (table_name, row_id, field, source, base_version, current_version,
+			attempted_value, attempted_by, current_value, current_by, resolution)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := e.Exec(query, c.Table, c.RowID, c.Field, c.Source, c.BaseVersion, c.CurrentVersion,
+		c.AttemptedValue, c.AttemptedBy, c.CurrentValue, c.CurrentBy, c.Resolution)
+	if err != nil {
+		return fmt.Errorf("failed to log conflict on %s %s: %w", c.Table, c.RowID, err)
+	}
+	return nil
+}
+
+// ListConflicts returns the most recent conflicts, optionally only those of
+// one row.
+func ListConflicts(rowID string, limit int) ([]Conflict, error) {
+	query := `
+		SELECT id, table_name, row_id, field, source, base_version, current_version,
+			COALESCE(attempted_value, ''), COALESCE(attempted_by, ''),
+			COALESCE(current_value, ''), COALESCE(current_by, ''), resolution, created_at
+		FROM sync_conflicts
+		WHERE ? = '' OR row_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := DB.Query(query, rowID, rowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Conflict
+	for rows.Next() {
+		var c Conflict
+		if err := rows.Scan(&c.ID, &c.Table, &c.RowID, &c.Field, &c.Source, &c.BaseVersion, &c.CurrentVersion,
+			&c.AttemptedValue, &c.AttemptedBy, &c.CurrentValue, &c.CurrentBy, &c.Resolution, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+func productVersions(q queryer, uuids []string) (map[string]int64, error) {
+	versions := map[string]int64{}
+	if len(uuids) == 0 {
+		return versions, nil
+	}
+
+	args := make([]interface{}, len(uuids))
+	for i, id := range uuids {
+		args[i] = id
+	}
+
+	rows, err := q.Query("SELECT uuid, version FROM product WHERE uuid IN ("+placeholders(len(uuids))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var version int64
+		if err := rows.Scan(&id, &version); err != nil {
+			return nil, err
+		}
+		versions[id] = version
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Delivery is the stored outcome of a webhook request, replayed when the
+// same delivery ID is sent again.
+type Delivery struct {
+	ID         string
+	StatusCode int
+	Response   []byte
+}
+
+// GetDelivery returns the stored response for a delivery ID processed within
+// retention, or nil.
+func GetDelivery(id string, retention time.Duration) (*Delivery, error) {
+	query := `
+		SELECT delivery_id, status_code, response FROM webhook_deliveries
+		WHERE delivery_id = ? AND status_code > 0 AND created_at >= ?
+	`
+	var d Delivery
+	err := DB.QueryRow(query, id, time.Now().Add(-retention)).Scan(&d.ID, &d.StatusCode, &d.Response)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// TxClaimDelivery reserves a delivery ID for tx, so the response can be
+// stored with the changes it describes. A concurrent request with the same
+// ID waits on the row lock until tx ends. It reports false if the ID was
+// already processed within retention; an expired entry is taken over.
+func TxClaimDelivery(tx *sql.Tx, id string, retention time.Duration) (bool, error) {
+	now := time.Now()
+	query := `
+		INSERT INTO webhook_deliveries (delivery_id, status_code, created_at) VALUES (?, 0, ?)
+		ON DUPLICATE KEY UPDATE
+			status_code = IF(created_at < ?, 0, status_code),
+			response = IF(created_at < ?, NULL, response),
+			created_at = IF(created_at < ?, VALUES(created_at), created_at)
+	`
+	cutoff := now.Add(-retention)
+	res, err := tx.Exec(query, id, now, cutoff, cutoff, cutoff)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim delivery %s: %w", id, err)
+	}
+	// 1 for a new row, 2 for a taken over one, 0 when the row was left alone.
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TxSaveDelivery stores the response of a claimed delivery.
+func TxSaveDelivery(tx *sql.Tx, d Delivery) error {
+	_, err := tx.Exec("UPDATE webhook_deliveries SET status_code = ?, response = ? WHERE delivery_id = ?", d.StatusCode, d.Response, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to store delivery %s: %w", d.ID, err)
+	}
+	return nil
+}
+
+// PurgeDeliveries removes deliveries older than retention.
+func PurgeDeliveries(retention time.Duration) (int64, error) {
+	res, err := DB.Exec("DELETE FROM webhook_deliveries WHERE created_at < ?", time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
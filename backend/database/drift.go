@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Kinds of discrepancy found by the drift check.
+const (
+	DriftMismatch       = "mismatch"
+	DriftMissingInSheet = "missing_in_sheet"
+	DriftMissingInDB    = "missing_in_db"
+)
+
+// Discrepancy is a difference between a table and its tab found by the drift
+// check. Field is empty for rows missing on one side. Resolution names the
+// side that was copied over the other ("db" or "sheet"), or is empty when
+// the difference was only reported.
+type Discrepancy struct {
+	ID         int64     `json:"id"`
+	Table      string    `json:"table"`
+	RowID      string    `json:"row_id"`
+	Field      string    `json:"field,omitempty"`
+	Kind       string    `json:"kind"`
+	DBValue    string    `json:"db_value"`
+	SheetValue string    `json:"sheet_value"`
+	Resolution string    `json:"resolution"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// RecordDiscrepancies appends the results of a drift check to sync_drift.
+func RecordDiscrepancies(ds []Discrepancy) error {
+	if len(ds) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO sync_drift (table_name, row_id, field, kind, db_value, sheet_value, resolution) VALUES "
+	var args []interface{}
+	for i, d := range ds {
+		if i > 0 {
+			query += ", "
+		}
+		query += "(?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, d.Table, d.RowID, d.Field, d.Kind, d.DBValue, d.SheetValue, d.Resolution)
+	}
+
+	if _, err := DB.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to record drift: %w", err)
+	}
+	return nil
+}
+
+// ListDiscrepancies returns the most recent discrepancies, optionally only
+// those of one table.
+func ListDiscrepancies(table string, limit int) ([]Discrepancy, error) {
+	query := `
+		SELECT id, table_name, row_id, field, kind, COALESCE(db_value, ''), COALESCE(sheet_value, ''), resolution, detected_at
+		FROM sync_drift
+		WHERE ? = '' OR table_name = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := DB.Query(query, table, table, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Discrepancy
+	for rows.Next() {
+		var d Discrepancy
+		if err := rows.Scan(&d.ID, &d.Table, &d.RowID, &d.Field, &d.Kind, &d.DBValue, &d.SheetValue, &d.Resolution, &d.DetectedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
+
+// OutboxRowIDs returns the IDs of a table's rows that still have an outbox
+// entry. Their sheet rows are known to be behind.
+func OutboxRowIDs(table string) (map[string]bool, error) {
+	rows, err := DB.Query("SELECT row_id FROM sync_outbox WHERE table_name = ?", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
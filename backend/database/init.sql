@@ -10,9 +10,18 @@ CREATE TABLE IF NOT EXISTS product (
     price DECIMAL(10,2) DEFAULT 0.00,              
     discount BOOLEAN DEFAULT FALSE,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    last_updated_by VARCHAR(50) DEFAULT 'system'
+    last_updated_by VARCHAR(50) DEFAULT 'system',
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    -- Set when the product is deleted, it stays in the trash until restored.
+    deleted_at TIMESTAMP NULL DEFAULT NULL
 );
 
+-- Every change to a product bumps its version, whatever wrote it. Writers
+-- that send the version they last saw get conflicts detected.
+DROP TRIGGER IF EXISTS product_version;
+CREATE TRIGGER product_version BEFORE UPDATE ON product
+FOR EACH ROW SET NEW.version = OLD.version + 1;
+
 CREATE TABLE IF NOT EXISTS oauth_tokens (
     user_email VARCHAR(255) NOT NULL PRIMARY KEY,
     access_token TEXT NOT NULL,
@@ -27,11 +36,132 @@ CREATE TABLE IF NOT EXISTS sheet_mappings (
     id INT AUTO_INCREMENT PRIMARY KEY,
     name VARCHAR(255) NOT NULL UNIQUE, 
     spreadsheet_id VARCHAR(255) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    table_name VARCHAR(64),
+    primary_key VARCHAR(64),
+    tab_name VARCHAR(100),
+    columns_json JSON,
+    soft_delete_column VARCHAR(64),
+    last_reconciled_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_mapping_table (spreadsheet_id, table_name)
+);
+
+CREATE TABLE IF NOT EXISTS cdc_checkpoints (
+    name VARCHAR(64) NOT NULL PRIMARY KEY,
+    binlog_file VARCHAR(255) NOT NULL,
+    binlog_pos INT UNSIGNED NOT NULL,
+    gtid_set TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Touched as the first statement of a transaction to tag where its changes
+-- came from. The CDC listener reads the tag from the binlog.
+CREATE TABLE IF NOT EXISTS sync_origin (
+    origin VARCHAR(32) NOT NULL PRIMARY KEY,
+    txn_count BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Row changes that could not be written to the sheet yet. Pending entries
+-- are retried by the worker; after too many attempts they are marked failed
+-- and wait for an operator (see /api/admin/outbox).
+CREATE TABLE IF NOT EXISTS sync_outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    table_name VARCHAR(64) NOT NULL,
+    row_id VARCHAR(255) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    payload JSON,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 1,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uniq_outbox_row (table_name, row_id),
+    KEY idx_outbox_due (status, next_attempt_at)
+);
+
+-- Differences between a table and its tab found by the periodic drift check
+-- (see /api/admin/drift). resolution is the side that won when auto-heal is
+-- on, empty otherwise.
+CREATE TABLE IF NOT EXISTS sync_drift (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    table_name VARCHAR(64) NOT NULL,
+    row_id VARCHAR(255) NOT NULL,
+    field VARCHAR(64) NOT NULL DEFAULT '',
+    kind VARCHAR(32) NOT NULL,
+    db_value TEXT,
+    sheet_value TEXT,
+    resolution VARCHAR(16) NOT NULL DEFAULT '',
+    detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_drift_table (table_name, id)
+);
+
+-- Concurrent edits caught by version checks, with both sides of the change.
+-- resolution is "applied" or "rejected" according to CONFLICT_POLICY.
+CREATE TABLE IF NOT EXISTS sync_conflicts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    table_name VARCHAR(64) NOT NULL,
+    row_id VARCHAR(255) NOT NULL,
+    field VARCHAR(64) NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    base_version INT UNSIGNED NOT NULL,
+    current_version INT UNSIGNED NOT NULL,
+    attempted_value TEXT,
+    attempted_by VARCHAR(255),
+    current_value TEXT,
+    current_by VARCHAR(255),
+    resolution VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_conflicts_row (table_name, row_id)
+);
+
+-- Processed sheet webhook batches by delivery ID, with the response that was
+-- sent, so a redelivered batch is answered without applying it twice.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id VARCHAR(128) NOT NULL PRIMARY KEY,
+    status_code INT NOT NULL,
+    response MEDIUMBLOB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_deliveries_created (created_at)
+);
+
+-- Every change to a synced row, field by field, as seen by the CDC listener
+-- in the binlog's before and after images (see /api/products/{uuid}/history).
+-- action is insert, update, delete or restore; soft deletes count as deletes.
+CREATE TABLE IF NOT EXISTS change_history (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    table_name VARCHAR(64) NOT NULL,
+    row_id VARCHAR(255) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    field VARCHAR(64) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    author VARCHAR(255) NOT NULL DEFAULT '',
+    origin VARCHAR(16) NOT NULL,
+    binlog_file VARCHAR(255) NOT NULL,
+    binlog_pos INT UNSIGNED NOT NULL,
+    changed_at TIMESTAMP NOT NULL,
+    UNIQUE KEY uniq_history_change (binlog_file, binlog_pos, table_name, row_id, field),
+    KEY idx_history_row (table_name, row_id, changed_at)
+);
+
+-- Sheet cells the sync marked with a note and colour because the edit typed
+-- into them was not saved as typed. Only these are cleared again, notes and
+-- colours users put on cells are left alone.
+CREATE TABLE IF NOT EXISTS sheet_annotations (
+    table_name VARCHAR(64) NOT NULL,
+    row_id VARCHAR(255) NOT NULL,
+    column_name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (table_name, row_id, column_name)
 );
 
 CREATE USER IF NOT EXISTS 'replicator'@'%' IDENTIFIED WITH mysql_native_password BY 'password';
 GRANT REPLICATION SLAVE, REPLICATION CLIENT, SELECT ON *.* TO 'replicator'@'%';
+GRANT INSERT, UPDATE, DELETE ON interndb.* TO 'replicator'@'%';
+-- The backend adds tables and columns missing from older installs on start.
+GRANT CREATE, ALTER, TRIGGER ON interndb.* TO 'replicator'@'%';
 FLUSH PRIVILEGES;
 
 INSERT IGNORE INTO product (uuid, product_name, quantity, price, discount) VALUES
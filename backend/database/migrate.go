@@ -0,0 +1,111 @@
+package database
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"strings"
+)
+
+//go:embed init.sql
+var initSQL string
+
+// column is a column added to a table after it was first created.
+type column struct {
+	table, name, definition string
+}
+
+// addedColumns are the columns init.sql has gained since the tables were
+// first shipped, in the order they appear there.
+var addedColumns = []column{
+	{"product", "version", "INT UNSIGNED NOT NULL DEFAULT 1"},
+	{"product", "deleted_at", "TIMESTAMP NULL DEFAULT NULL"},
+	{"sheet_mappings", "table_name", "VARCHAR(64)"},
+	{"sheet_mappings", "primary_key", "VARCHAR(64)"},
+	{"sheet_mappings", "tab_name", "VARCHAR(100)"},
+	{"sheet_mappings", "columns_json", "JSON"},
+	{"sheet_mappings", "soft_delete_column", "VARCHAR(64)"},
+	{"sheet_mappings", "last_reconciled_at", "TIMESTAMP NULL"},
+	{"cdc_checkpoints", "gtid_set", "TEXT"},
+}
+
+// Migrate brings a database created by an older init.sql up to date: it
+// creates the tables init.sql has since gained, adds missing columns, keys
+// and the product_version trigger. Everything that is already there is left
+// alone, so it is safe to run on every start.
+func Migrate() error {
+	for _, stmt := range createTableStatements(initSQL) {
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+
+	for _, c := range addedColumns {
+		var n int
+		err := DB.QueryRow(`
+			SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+		`, c.table, c.name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", c.table, c.name, err)
+		}
+		log.Printf("Migrated: added column %s.%s", c.table, c.name)
+	}
+
+	var n int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'sheet_mappings' AND INDEX_NAME = 'uniq_mapping_table'
+	`).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := DB.Exec("ALTER TABLE sheet_mappings ADD UNIQUE KEY uniq_mapping_table (spreadsheet_id, table_name)"); err != nil {
+			return fmt.Errorf("failed to add key uniq_mapping_table: %w", err)
+		}
+		log.Println("Migrated: added key sheet_mappings.uniq_mapping_table")
+	}
+
+	err = DB.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.TRIGGERS
+		WHERE TRIGGER_SCHEMA = DATABASE() AND TRIGGER_NAME = 'product_version'
+	`).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := DB.Exec("CREATE TRIGGER product_version BEFORE UPDATE ON product FOR EACH ROW SET NEW.version = OLD.version + 1")
+		if err != nil {
+			return fmt.Errorf("failed to create trigger product_version: %w", err)
+		}
+		log.Println("Migrated: added trigger product_version")
+	}
+	return nil
+}
+
+// createTableStatements returns the CREATE TABLE IF NOT EXISTS statements of
+// a SQL script, without its comments.
+func createTableStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		stmt = strings.TrimSpace(stmt)
+		if strings.HasPrefix(stmt, "CREATE TABLE IF NOT EXISTS") {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// OriginSheet tags transactions applying edits made in the sheet, OriginAPI
+// those made through the REST API and OriginDrift the drift check copying
+// sheet values over drifted rows. OriginSQL is what the change history shows
+// for transactions without a marker, e.g. made by hand in SQL.
+const (
+	OriginSheet = "sheet"
+	OriginAPI   = "api"
+	OriginDrift = "drift"
+	OriginSQL   = "sql"
+)
+
+// TxMarkOrigin records origin for the transaction by bumping its row in
+// sync_origin. It must run before any other write in tx so that the CDC
+// listener sees the marker ahead of the rows it applies to.
+func TxMarkOrigin(tx *sql.Tx, origin string) error {
+	query := `
+		INSERT INTO sync_origin (origin, txn_count) VALUES (?, 1)
+		ON DUPLICATE KEY UPDATE txn_count = txn_count + 1
+	`
+	if _, err := tx.Exec(query, origin); err != nil {
+		return fmt.Errorf("failed to mark transaction origin: %w", err)
+	}
+	return nil
+}
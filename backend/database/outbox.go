@@ -0,0 +1,193 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Outbox entry statuses.
+const (
+	OutboxPending = "pending"
+	OutboxFailed  = "failed"
+)
+
+// OutboxEntry is a row change that still has to be written to the sheet.
+// There is at most one entry per row; re-queueing a row replaces its entry.
+type OutboxEntry struct {
+	ID            int64                  `json:"id"`
+	Table         string                 `json:"table"`
+	RowID         string                 `json:"row_id"`
+	Action        string                 `json:"action"`
+	Payload       map[string]interface{} `json:"payload"`
+	Status        string                 `json:"status"`
+	Attempts      int                    `json:"attempts"`
+	LastError     string                 `json:"last_error"`
+	NextAttemptAt time.Time              `json:"next_attempt_at"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+const outboxColumns = `id, table_name, row_id, action, payload, status, attempts, COALESCE(last_error, ''), next_attempt_at, created_at`
+
+// EnqueueOutbox queues a row change. A new change to a row whose entry has
+// failed puts it back in the pending state.
+func EnqueueOutbox(table, rowID, action string, payload map[string]interface{}, lastError string) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO sync_outbox (table_name, row_id, action, payload, last_error)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			action = VALUES(action),
+			payload = VALUES(payload),
+			last_error = VALUES(last_error),
+			status = 'pending',
+			attempts = attempts + 1
+	`
+	_, err = DB.Exec(query, table, rowID, action, data, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to queue %s %s: %w", table, rowID, err)
+	}
+	return nil
+}
+
+// DueOutboxEntries returns the pending entries whose next attempt is due,
+// oldest first.
+func DueOutboxEntries(limit int) ([]OutboxEntry, error) {
+	query := `SELECT ` + outboxColumns + `
+		FROM sync_outbox
+		WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY id
+		LIMIT ?
+	`
+	return queryOutbox(query, limit)
+}
+
+// ListOutbox returns entries with the given status, or all entries when
+// status is empty.
+func ListOutbox(status string, limit int) ([]OutboxEntry, error) {
+	query := `SELECT ` + outboxColumns + `
+		FROM sync_outbox
+		WHERE ? = '' OR status = ?
+		ORDER BY id
+		LIMIT ?
+	`
+	return queryOutbox(query, status, status, limit)
+}
+
+func queryOutbox(query string, args ...interface{}) ([]OutboxEntry, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		var payload []byte
+
+		if err := rows.Scan(&e.ID, &e.Table, &e.RowID, &e.Action, &payload, &e.Status, &e.Attempts, &e.LastError, &e.NextAttemptAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &e.Payload); err != nil {
+				return nil, fmt.Errorf("bad payload in outbox entry %d: %w", e.ID, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func DeleteOutboxEntries(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query := "DELETE FROM sync_outbox WHERE id IN (" + placeholders(len(ids)) + ")"
+	_, err := DB.Exec(query, int64Args(ids)...)
+	return err
+}
+
+// RescheduleOutboxEntries records a failed retry and pushes the next attempt
+// back exponentially, up to an hour. Entries that reach maxAttempts are
+// marked failed and are no longer retried automatically.
+func RescheduleOutboxEntries(ids []int64, lastError string, maxAttempts int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query := `
+		UPDATE sync_outbox SET
+			attempts = attempts + 1,
+			last_error = ?,
+			status = IF(attempts >= ?, 'failed', 'pending'),
+			next_attempt_at = CURRENT_TIMESTAMP + INTERVAL LEAST(POW(2, attempts), 60) MINUTE
+		WHERE id IN (` + placeholders(len(ids)) + ")"
+	args := append([]interface{}{lastError, maxAttempts}, int64Args(ids)...)
+	_, err := DB.Exec(query, args...)
+	return err
+}
+
+// FailOutboxEntry marks an entry failed without further retries, for changes
+// that cannot be synced however often they are tried.
+func FailOutboxEntry(id int64, lastError string) error {
+	_, err := DB.Exec("UPDATE sync_outbox SET status = 'failed', last_error = ? WHERE id = ?", lastError, id)
+	return err
+}
+
+// RetryOutboxEntry makes an entry pending and due now, with a fresh attempt
+// count. It reports whether the entry exists.
+func RetryOutboxEntry(id int64) (bool, error) {
+	query := `
+		UPDATE sync_outbox SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	return execAffected(query, id)
+}
+
+// RetryAllOutbox makes every entry with the given status pending and due now.
+func RetryAllOutbox(status string) (int64, error) {
+	query := `
+		UPDATE sync_outbox SET
+			attempts = IF(status = 'failed', 0, attempts),
+			status = 'pending',
+			next_attempt_at = CURRENT_TIMESTAMP
+		WHERE status = ?
+	`
+	res, err := DB.Exec(query, status)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DiscardOutboxEntry drops an entry without syncing it. It reports whether
+// the entry existed.
+func DiscardOutboxEntry(id int64) (bool, error) {
+	return execAffected("DELETE FROM sync_outbox WHERE id = ?", id)
+}
+
+func execAffected(query string, args ...interface{}) (bool, error) {
+	res, err := DB.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func int64Args(ids []int64) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
@@ -0,0 +1,326 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+)
+
+// Product is a row of the product table.
+type Product struct {
+	UUID          string     `json:"uuid"`
+	ProductName   string     `json:"product_name"`
+	Quantity      int        `json:"quantity"`
+	Price         float64    `json:"price"`
+	Discount      bool       `json:"discount"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	LastUpdatedBy string     `json:"last_updated_by"`
+	Version       int64      `json:"version"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Row returns the product keyed by column name, with values converted the
+// way GetTableRow converts them.
+func (p Product) Row() map[string]interface{} {
+	return map[string]interface{}{
+		"uuid":            p.UUID,
+		"product_name":    p.ProductName,
+		"quantity":        int64(p.Quantity),
+		"price":           p.Price,
+		"discount":        p.Discount,
+		"updated_at":      p.UpdatedAt.UTC().Format(TimeLayout),
+		"last_updated_by": p.LastUpdatedBy,
+		"version":         p.Version,
+	}
+}
+
+// ErrProductNotFound is returned for products that don't exist or are in
+// the trash.
+var ErrProductNotFound = errors.New("product not found")
+
+// ErrDeliveryInProgress is returned by BulkUpsert while another request is
+// applying the same delivery.
+var ErrDeliveryInProgress = errors.New("delivery is already being processed")
+
+// ConflictError is returned for a write the conflict policy rejected.
+// Current is the product as stored.
+type ConflictError struct {
+	Conflicts []Conflict
+	Current   Product
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("product changed since version %d", e.Conflicts[0].BaseVersion)
+}
+
+// ProductWrite says where a write comes from and who made it. With a
+// BaseVersion, the version the writer last saw, changes made since then are
+// conflicts handled according to Policy.
+type ProductWrite struct {
+	Origin      string
+	Author      string
+	BaseVersion *int64
+	Policy      string
+}
+
+// Edit actions.
+const (
+	EditUpdate = "update"
+	EditDelete = "delete"
+)
+
+// Outcomes of an edit.
+const (
+	EditApplied    = "applied"
+	EditConflict   = "conflict"    // rejected by the conflict policy
+	EditFailed     = "failed"      // database error
+	EditRolledBack = "rolled_back" // fine, but the batch was not applied
+)
+
+// ProductEdit is one change of a BulkUpsert: a field set on a product,
+// which is created or taken out of the trash if needed, or a deletion.
+type ProductEdit struct {
+	UUID        string
+	Action      string
+	Field       string
+	Value       interface{}
+	Author      string
+	BaseVersion *int64
+}
+
+// EditResult is the outcome of the edit at the same index.
+type EditResult struct {
+	Status string
+	Error  string
+}
+
+// BulkOptions control a BulkUpsert.
+//
+// With DeliveryID set a batch is applied only once: a delivery seen within
+// DeliveryRetention is not applied again, its stored response is returned in
+// Replay. The response is built by Respond and stored in the transaction
+// applying the edits.
+type BulkOptions struct {
+	Origin string
+	Policy string
+	// Atomic applies the edits only if every one of them can be.
+	Atomic bool
+	// DryRun checks versions and records conflicts, but writes nothing.
+	DryRun bool
+
+	DeliveryID        string
+	DeliveryRetention time.Duration
+	Respond           func(BulkResult) (Delivery, error)
+}
+
+// BulkResult reports what a BulkUpsert did. Versions holds the new version
+// of every product edited.
+type BulkResult struct {
+	Results    []EditResult
+	Conflicts  []Conflict
+	Versions   map[string]int64
+	RolledBack bool
+	Replay     *Delivery
+}
+
+// ProductRepository reads and writes products. Deletes are soft: deleted
+// products go to the trash and are left out of everything but List(true).
+type ProductRepository interface {
+	// List returns the products, or with deleted the ones in the trash,
+	// most recently deleted first.
+	List(deleted bool) ([]Product, error)
+	Get(uuid string) (Product, error)
+	Create(p Product, w ProductWrite) (Product, error)
+	// Patch sets the given writable columns of a product.
+	Patch(uuid string, fields map[string]interface{}, w ProductWrite) (Product, error)
+	// Delete moves a product to the trash. Deleting one that doesn't exist
+	// or is already deleted is not an error.
+	Delete(uuid string, w ProductWrite) error
+	// Restore takes a product out of the trash.
+	Restore(uuid string, w ProductWrite) error
+	// BulkUpsert applies a batch of edits in one transaction. Edits that
+	// fail are reported in the result and, unless the batch is atomic, don't
+	// hold back the rest.
+	BulkUpsert(edits []ProductEdit, opts BulkOptions) (BulkResult, error)
+}
+
+// editStore is a transaction BulkUpsert applies edits in.
+type editStore interface {
+	// lock returns a product, including deleted ones, or nil.
+	lock(uuid string) (*Product, error)
+	upsertField(uuid, field string, value interface{}, author string) error
+	delete(uuid, author string) error
+	logConflict(c Conflict) error
+}
+
+// applyEdits is the part of BulkUpsert shared by the implementations. Versions
+// are checked once per product, before the batch's own writes bump them.
+func applyEdits(s editStore, edits []ProductEdit, opts BulkOptions) (BulkResult, []string) {
+	type versionCheck struct {
+		current *Product
+		base    int64
+	}
+	checked := map[string]*versionCheck{}
+	var uuids []string
+
+	res := BulkResult{Results: make([]EditResult, len(edits)), Conflicts: []Conflict{}}
+
+	for i, e := range edits {
+		r := &res.Results[i]
+
+		check, seen := checked[e.UUID]
+		if !seen {
+			if e.BaseVersion != nil {
+				current, err := s.lock(e.UUID)
+				if err != nil {
+					log.Printf("Batch item failed (%s): %v", e.UUID, err)
+					r.Status, r.Error = EditFailed, err.Error()
+					continue
+				}
+				if current != nil && current.Version != *e.BaseVersion {
+					check = &versionCheck{current: current, base: *e.BaseVersion}
+				}
+			}
+			checked[e.UUID] = check
+			uuids = append(uuids, e.UUID)
+		}
+
+		if check != nil {
+			c := newConflict(opts.Origin, e.Field, check.base, *check.current, e.Value, e.Author, opts.Policy)
+			if err := s.logConflict(c); err != nil {
+				log.Printf("Batch Error: %v", err)
+			}
+			res.Conflicts = append(res.Conflicts, c)
+			if c.Resolution == ConflictRejected {
+				log.Printf("Rejected %s %s of %s %s, row changed since version %d", opts.Origin, e.Action, e.UUID, e.Field, check.base)
+				r.Status = EditConflict
+				r.Error = fmt.Sprintf("row changed since version %d (now %d by %s)", c.BaseVersion, c.CurrentVersion, c.CurrentBy)
+				continue
+			}
+		}
+
+		if opts.DryRun {
+			r.Status = EditRolledBack
+			continue
+		}
+
+		var err error
+		if e.Action == EditDelete {
+			err = s.delete(e.UUID, e.Author)
+		} else {
+			err = s.upsertField(e.UUID, e.Field, e.Value, e.Author)
+		}
+		if err != nil {
+			log.Printf("Batch item failed (%s): %v", e.UUID, err)
+			r.Status, r.Error = EditFailed, err.Error()
+		} else {
+			r.Status = EditApplied
+		}
+	}
+	return res, uuids
+}
+
+// rollBack marks a batch whose transaction was rolled back. Its conflicts are
+// logged again as rejected, they happened even if nothing was written.
+func (res *BulkResult) rollBack(logConflict func(Conflict) error) {
+	res.RolledBack = true
+	for i := range res.Results {
+		if res.Results[i].Status == EditApplied {
+			res.Results[i].Status = EditRolledBack
+		}
+	}
+	for i := range res.Conflicts {
+		res.Conflicts[i].Resolution = ConflictRejected
+		if err := logConflict(res.Conflicts[i]); err != nil {
+			log.Printf("Batch Error: %v", err)
+		}
+	}
+}
+
+// failed reports whether any edit was not applied.
+func (res *BulkResult) failed() bool {
+	for _, r := range res.Results {
+		if r.Status != EditApplied {
+			return true
+		}
+	}
+	return false
+}
+
+// writeConflicts describes a write based on w to a product that changed
+// since w.BaseVersion: one conflict per field, or one without a field for a
+// delete or restore. It is nil if there is no conflict.
+func writeConflicts(current Product, fields map[string]interface{}, w ProductWrite) []Conflict {
+	if w.BaseVersion == nil || current.Version == *w.BaseVersion {
+		return nil
+	}
+	if len(fields) == 0 {
+		return []Conflict{newConflict(w.Origin, "", *w.BaseVersion, current, nil, w.Author, w.Policy)}
+	}
+	return fieldConflicts(w.Origin, *w.BaseVersion, current, fields, w.Author, w.Policy)
+}
+
+// fieldConflicts describes a write of fields to a product that changed since
+// base, one conflict per field in column order.
+func fieldConflicts(source string, base int64, current Product, fields map[string]interface{}, author, policy string) []Conflict {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	// Columns the mapping doesn't show go last.
+	order := map[string]int{}
+	if m, ok := config.MappingForTable("product"); ok {
+		for i, name := range m.ColumnNames() {
+			order[name] = i + 1
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, oj := order[names[i]], order[names[j]]
+		switch {
+		case oi == 0 && oj == 0:
+			return names[i] < names[j]
+		case oi == 0 || oj == 0:
+			return oj == 0
+		}
+		return oi < oj
+	})
+
+	var conflicts []Conflict
+	for _, name := range names {
+		conflicts = append(conflicts, newConflict(source, name, base, current, fields[name], author, policy))
+	}
+	return conflicts
+}
+
+// newConflict describes a write of value to field of a product that changed
+// since base.
+func newConflict(source, field string, base int64, current Product, value interface{}, author, policy string) Conflict {
+	c := Conflict{
+		Table:          "product",
+		RowID:          current.UUID,
+		Field:          field,
+		Source:         source,
+		BaseVersion:    base,
+		CurrentVersion: current.Version,
+		AttemptedValue: conflictValue(value),
+		AttemptedBy:    author,
+		CurrentValue:   conflictValue(current.Row()[field]),
+		CurrentBy:      current.LastUpdatedBy,
+		Resolution:     ConflictRejected,
+	}
+	if ConflictWins(policy, source) {
+		c.Resolution = ConflictApplied
+	}
+	return c
+}
+
+func conflictValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,330 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryProducts is a ProductRepository kept in memory, for tests. It follows
+// the rules of the product table: every change bumps the version, deletes
+// are soft, edits from the sheet create missing products.
+type MemoryProducts struct {
+	mu         sync.Mutex
+	products   map[string]Product
+	conflicts  []Conflict
+	deliveries map[string]memoryDelivery
+
+	// Now is the clock for updated_at and deleted_at, time.Now if nil.
+	Now func() time.Time
+}
+
+type memoryDelivery struct {
+	Delivery
+	at time.Time
+}
+
+// NewMemoryProducts returns a repository holding the given products.
+func NewMemoryProducts(products ...Product) *MemoryProducts {
+	m := &MemoryProducts{products: map[string]Product{}, deliveries: map[string]memoryDelivery{}}
+	for _, p := range products {
+		if p.Version == 0 {
+			p.Version = 1
+		}
+		m.products[p.UUID] = p
+	}
+	return m
+}
+
+// Conflicts returns the conflicts logged so far, oldest first.
+func (m *MemoryProducts) Conflicts() []Conflict {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Conflict{}, m.conflicts...)
+}
+
+func (m *MemoryProducts) List(deleted bool) ([]Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var products []Product
+	for _, p := range m.products {
+		if (p.DeletedAt != nil) == deleted {
+			products = append(products, p)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if deleted {
+			return products[i].DeletedAt.After(*products[j].DeletedAt)
+		}
+		return products[i].UUID < products[j].UUID
+	})
+	return products, nil
+}
+
+func (m *MemoryProducts) Get(uuid string) (Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[uuid]
+	if !ok || p.DeletedAt != nil {
+		return Product{}, ErrProductNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryProducts) Create(p Product, w ProductWrite) (Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[p.UUID]; ok {
+		return Product{}, fmt.Errorf("duplicate product %s", p.UUID)
+	}
+	p.UpdatedAt = m.now()
+	p.LastUpdatedBy = w.Author
+	p.Version = 1
+	p.DeletedAt = nil
+	m.products[p.UUID] = p
+	return p, nil
+}
+
+func (m *MemoryProducts) Patch(uuid string, fields map[string]interface{}, w ProductWrite) (Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[uuid]
+	if !ok || p.DeletedAt != nil {
+		return Product{}, ErrProductNotFound
+	}
+
+	if err := m.checkVersion(p, fields, w); err != nil {
+		return Product{}, err
+	}
+
+	for name, val := range fields {
+		if !ProductFieldWritable(name) {
+			return Product{}, fmt.Errorf("invalid database field: %s", name)
+		}
+		if err := setProductField(&p, name, val); err != nil {
+			return Product{}, err
+		}
+	}
+	m.touch(&p, w.Author)
+	m.products[uuid] = p
+	return p, nil
+}
+
+func (m *MemoryProducts) Delete(uuid string, w ProductWrite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.products[uuid]; ok && p.DeletedAt == nil {
+		if err := m.checkVersion(p, nil, w); err != nil {
+			return err
+		}
+	}
+	return m.edits(m.products).delete(uuid, w.Author)
+}
+
+func (m *MemoryProducts) Restore(uuid string, w ProductWrite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[uuid]
+	if !ok || p.DeletedAt == nil {
+		return ErrProductNotFound
+	}
+	if err := m.checkVersion(p, nil, w); err != nil {
+		return err
+	}
+	p.DeletedAt = nil
+	m.touch(&p, w.Author)
+	m.products[uuid] = p
+	return nil
+}
+
+func (m *MemoryProducts) BulkUpsert(edits []ProductEdit, opts BulkOptions) (BulkResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if opts.DeliveryID != "" {
+		if d, ok := m.deliveries[opts.DeliveryID]; ok && m.now().Sub(d.at) < opts.DeliveryRetention {
+			replay := d.Delivery
+			return BulkResult{Replay: &replay}, nil
+		}
+	}
+
+	// Edits go to a copy, which replaces the products on commit.
+	staged := make(map[string]Product, len(m.products))
+	for id, p := range m.products {
+		staged[id] = p
+	}
+	tx := m.edits(staged)
+
+	res, uuids := applyEdits(tx, edits, opts)
+
+	if opts.DryRun || (opts.Atomic && res.failed()) {
+		res.rollBack(func(c Conflict) error {
+			m.conflicts = append(m.conflicts, c)
+			return nil
+		})
+		return res, nil
+	}
+
+	res.Versions = map[string]int64{}
+	for _, id := range uuids {
+		if p, ok := staged[id]; ok {
+			res.Versions[id] = p.Version
+		}
+	}
+
+	if opts.Respond != nil {
+		d, err := opts.Respond(res)
+		if err != nil {
+			return BulkResult{}, err
+		}
+		if opts.DeliveryID != "" {
+			d.ID = opts.DeliveryID
+			m.deliveries[d.ID] = memoryDelivery{Delivery: d, at: m.now()}
+		}
+	}
+
+	m.products = staged
+	m.conflicts = append(m.conflicts, tx.conflicts...)
+	return res, nil
+}
+
+// checkVersion logs the conflicts of a write to p if it changed since the
+// version the writer saw, and returns a ConflictError if the policy rejects
+// the write.
+func (m *MemoryProducts) checkVersion(p Product, fields map[string]interface{}, w ProductWrite) error {
+	conflicts := writeConflicts(p, fields, w)
+	m.conflicts = append(m.conflicts, conflicts...)
+	if len(conflicts) > 0 && !ConflictWins(w.Policy, w.Origin) {
+		return &ConflictError{Conflicts: conflicts, Current: p}
+	}
+	return nil
+}
+
+func (m *MemoryProducts) now() time.Time {
+	if m.Now != nil {
+		return m.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// touch records a change the way the product table's defaults and trigger do.
+func (m *MemoryProducts) touch(p *Product, author string) {
+	p.UpdatedAt = m.now()
+	p.LastUpdatedBy = author
+	p.Version++
+}
+
+func (m *MemoryProducts) edits(products map[string]Product) *memoryEdits {
+	return &memoryEdits{repo: m, products: products}
+}
+
+// memoryEdits applies BulkUpsert edits to a set of products.
+type memoryEdits struct {
+	repo      *MemoryProducts
+	products  map[string]Product
+	conflicts []Conflict
+}
+
+func (s *memoryEdits) lock(uuid string) (*Product, error) {
+	p, ok := s.products[uuid]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (s *memoryEdits) upsertField(uuid, field string, value interface{}, author string) error {
+	if !ProductFieldWritable(field) {
+		return fmt.Errorf("invalid database field: %s", field)
+	}
+
+	p, ok := s.products[uuid]
+	if !ok {
+		p = Product{UUID: uuid, ProductName: "New Product"}
+	}
+	if err := setProductField(&p, field, value); err != nil {
+		return err
+	}
+	p.DeletedAt = nil
+	if ok {
+		s.repo.touch(&p, author)
+	} else {
+		p.UpdatedAt, p.LastUpdatedBy, p.Version = s.repo.now(), author, 1
+	}
+	s.products[uuid] = p
+	return nil
+}
+
+func (s *memoryEdits) delete(uuid, author string) error {
+	p, ok := s.products[uuid]
+	if !ok || p.DeletedAt != nil {
+		return nil
+	}
+	now := s.repo.now()
+	p.DeletedAt = &now
+	s.repo.touch(&p, author)
+	s.products[uuid] = p
+	return nil
+}
+
+func (s *memoryEdits) logConflict(c Conflict) error {
+	s.conflicts = append(s.conflicts, c)
+	return nil
+}
+
+// setProductField assigns a column value as decoded from JSON or coerced
+// from the sheet, converting it the way MySQL would.
+func setProductField(p *Product, field string, val interface{}) error {
+	switch field {
+	case "product_name":
+		p.ProductName = fmt.Sprintf("%v", val)
+	case "quantity":
+		f, err := floatValue(val)
+		if err != nil {
+			return err
+		}
+		p.Quantity = int(f)
+	case "price":
+		f, err := floatValue(val)
+		if err != nil {
+			return err
+		}
+		p.Price = f
+	case "discount":
+		switch v := val.(type) {
+		case bool:
+			p.Discount = v
+		default:
+			f, err := floatValue(val)
+			if err != nil {
+				return err
+			}
+			p.Discount = f != 0
+		}
+	default:
+		return fmt.Errorf("invalid database field: %s", field)
+	}
+	return nil
+}
+
+func floatValue(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("%v is not a number", val)
+}
@@ -0,0 +1,347 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MySQLProducts is the ProductRepository backed by the product table. Every
+// write is tagged with its origin for the CDC listener.
+type MySQLProducts struct{}
+
+const productColumns = `
+	uuid, COALESCE(product_name, ''), COALESCE(quantity, 0), COALESCE(price, 0), COALESCE(discount, FALSE),
+	updated_at, COALESCE(last_updated_by, ''), version, deleted_at
+`
+
+func (MySQLProducts) List(deleted bool) ([]Product, error) {
+	query := "SELECT " + productColumns + " FROM product WHERE deleted_at IS NULL"
+	if deleted {
+		query = "SELECT " + productColumns + " FROM product WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC"
+	}
+	return queryProducts(DB, query)
+}
+
+func (MySQLProducts) Get(uuid string) (Product, error) {
+	products, err := queryProducts(DB, "SELECT "+productColumns+" FROM product WHERE uuid = ? AND deleted_at IS NULL", uuid)
+	if err != nil {
+		return Product{}, err
+	}
+	if len(products) == 0 {
+		return Product{}, ErrProductNotFound
+	}
+	return products[0], nil
+}
+
+func (MySQLProducts) Create(p Product, w ProductWrite) (Product, error) {
+	tx, err := beginWrite(w.Origin)
+	if err != nil {
+		return Product{}, err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO product (uuid, product_name, quantity, price, discount, last_updated_by) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(query, p.UUID, p.ProductName, p.Quantity, p.Price, p.Discount, w.Author); err != nil {
+		return Product{}, err
+	}
+
+	created, err := lockProduct(tx, p.UUID)
+	if err != nil {
+		return Product{}, err
+	}
+	return *created, tx.Commit()
+}
+
+func (MySQLProducts) Patch(uuid string, fields map[string]interface{}, w ProductWrite) (Product, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if !ProductFieldWritable(name) {
+			return Product{}, fmt.Errorf("invalid database field: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tx, err := beginWrite(w.Origin)
+	if err != nil {
+		return Product{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := lockProduct(tx, uuid)
+	if err != nil {
+		return Product{}, err
+	}
+	if current == nil || current.DeletedAt != nil {
+		return Product{}, ErrProductNotFound
+	}
+
+	if err := checkVersion(tx, *current, fields, w); err != nil {
+		return Product{}, err
+	}
+
+	var sets []string
+	var args []interface{}
+	for _, name := range names {
+		sets = append(sets, fmt.Sprintf("`%s` = ?", name))
+		args = append(args, fields[name])
+	}
+	sets = append(sets, "last_updated_by = ?")
+	args = append(args, w.Author, uuid)
+
+	if len(names) > 0 {
+		if _, err := tx.Exec("UPDATE product SET "+strings.Join(sets, ", ")+" WHERE uuid = ?", args...); err != nil {
+			return Product{}, err
+		}
+	}
+
+	updated, err := lockProduct(tx, uuid)
+	if err != nil {
+		return Product{}, err
+	}
+	return *updated, tx.Commit()
+}
+
+func (MySQLProducts) Delete(uuid string, w ProductWrite) error {
+	tx, err := beginWrite(w.Origin)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockProduct(tx, uuid)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.DeletedAt != nil {
+		return nil
+	}
+	if err := checkVersion(tx, *current, nil, w); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE product SET deleted_at = CURRENT_TIMESTAMP, last_updated_by = ? WHERE uuid = ?", w.Author, uuid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (MySQLProducts) Restore(uuid string, w ProductWrite) error {
+	tx, err := beginWrite(w.Origin)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockProduct(tx, uuid)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.DeletedAt == nil {
+		return ErrProductNotFound
+	}
+	if err := checkVersion(tx, *current, nil, w); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE product SET deleted_at = NULL, last_updated_by = ? WHERE uuid = ?", w.Author, uuid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (MySQLProducts) BulkUpsert(edits []ProductEdit, opts BulkOptions) (BulkResult, error) {
+	if opts.DeliveryID != "" {
+		d, err := GetDelivery(opts.DeliveryID, opts.DeliveryRetention)
+		if err != nil || d != nil {
+			return BulkResult{Replay: d}, err
+		}
+	}
+
+	tx, err := beginWrite(opts.Origin)
+	if err != nil {
+		return BulkResult{}, err
+	}
+	defer tx.Rollback()
+
+	if opts.DeliveryID != "" {
+		claimed, err := TxClaimDelivery(tx, opts.DeliveryID, opts.DeliveryRetention)
+		if err != nil {
+			return BulkResult{}, err
+		}
+		if !claimed {
+			// A concurrent delivery of the same batch finished first.
+			tx.Rollback()
+			if d, err := GetDelivery(opts.DeliveryID, opts.DeliveryRetention); err == nil && d != nil {
+				return BulkResult{Replay: d}, nil
+			}
+			return BulkResult{}, ErrDeliveryInProgress
+		}
+	}
+
+	res, uuids := applyEdits(sqlEdits{tx}, edits, opts)
+
+	if opts.DryRun || (opts.Atomic && res.failed()) {
+		tx.Rollback()
+		res.rollBack(LogConflict)
+		return res, nil
+	}
+
+	if res.Versions, err = productVersions(tx, uuids); err != nil {
+		return BulkResult{}, fmt.Errorf("reading versions: %w", err)
+	}
+
+	if opts.Respond != nil {
+		d, err := opts.Respond(res)
+		if err != nil {
+			return BulkResult{}, err
+		}
+		// Stored in the same transaction, so the response is kept if and
+		// only if the changes it describes are.
+		if opts.DeliveryID != "" {
+			d.ID = opts.DeliveryID
+			if err := TxSaveDelivery(tx, d); err != nil {
+				return BulkResult{}, err
+			}
+		}
+	}
+
+	return res, tx.Commit()
+}
+
+// beginWrite starts a transaction tagged with origin.
+func beginWrite(origin string) (*sql.Tx, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := TxMarkOrigin(tx, origin); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// checkVersion logs the conflicts of a write to current if it changed since
+// the version the writer saw. If the policy rejects the write, tx is
+// committed to keep the conflicts and a ConflictError is returned.
+func checkVersion(tx *sql.Tx, current Product, fields map[string]interface{}, w ProductWrite) error {
+	conflicts := writeConflicts(current, fields, w)
+	if len(conflicts) == 0 {
+		return nil
+	}
+	for _, c := range conflicts {
+		if err := logConflict(tx, c); err != nil {
+			return err
+		}
+	}
+	if ConflictWins(w.Policy, w.Origin) {
+		return nil
+	}
+	// The conflicts are kept even though nothing is written.
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return &ConflictError{Conflicts: conflicts, Current: current}
+}
+
+// sqlEdits applies BulkUpsert edits in a transaction.
+type sqlEdits struct {
+	tx *sql.Tx
+}
+
+func (s sqlEdits) lock(uuid string) (*Product, error) {
+	return lockProduct(s.tx, uuid)
+}
+
+func (s sqlEdits) upsertField(uuid, field string, value interface{}, author string) error {
+	if !ProductFieldWritable(field) {
+		return fmt.Errorf("invalid database field: %s", field)
+	}
+
+	// Editing a product in the trash restores it, the row being edited is
+	// evidently still in the sheet.
+	var query string
+	switch field {
+	case "product_name":
+		query = `
+			INSERT INTO product (uuid, product_name, price, last_updated_by, updated_at)
+			VALUES (?, ?, 0.00, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				product_name = VALUES(product_name),
+				last_updated_by = VALUES(last_updated_by),
+				updated_at = VALUES(updated_at),
+				deleted_at = NULL
+		`
+
+	case "price":
+		query = `
+			INSERT INTO product (uuid, price, product_name, last_updated_by, updated_at)
+			VALUES (?, ?, 'New Product', ?, ?)
+			ON DUPLICATE KEY UPDATE
+				price = VALUES(price),
+				last_updated_by = VALUES(last_updated_by),
+				updated_at = VALUES(updated_at),
+				deleted_at = NULL
+		`
+
+	default:
+		query = fmt.Sprintf(`
+			INSERT INTO product (uuid, %s, product_name, price, last_updated_by, updated_at)
+			VALUES (?, ?, 'New Product', 0.00, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				%s = VALUES(%s),
+				last_updated_by = VALUES(last_updated_by),
+				updated_at = VALUES(updated_at),
+				deleted_at = NULL
+		`, field, field, field)
+	}
+	_, err := s.tx.Exec(query, uuid, value, author, time.Now())
+	return err
+}
+
+func (s sqlEdits) delete(uuid, author string) error {
+	_, err := s.tx.Exec("UPDATE product SET deleted_at = CURRENT_TIMESTAMP, last_updated_by = ? WHERE uuid = ? AND deleted_at IS NULL", author, uuid)
+	return err
+}
+
+func (s sqlEdits) logConflict(c Conflict) error {
+	return logConflict(s.tx, c)
+}
+
+// lockProduct reads a product, deleted or not, locking it until tx ends. It
+// returns nil if there is no such product.
+func lockProduct(tx *sql.Tx, uuid string) (*Product, error) {
+	products, err := queryProducts(tx, "SELECT "+productColumns+" FROM product WHERE uuid = ? FOR UPDATE", uuid)
+	if err != nil || len(products) == 0 {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+func queryProducts(q queryer, query string, args ...interface{}) ([]Product, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []Product
+	for rows.Next() {
+		var p Product
+		var updatedAt, deletedAt sql.NullTime
+		if err := rows.Scan(&p.UUID, &p.ProductName, &p.Quantity, &p.Price, &p.Discount,
+			&updatedAt, &p.LastUpdatedBy, &p.Version, &deletedAt); err != nil {
+			return nil, err
+		}
+		p.UpdatedAt = updatedAt.Time
+		if deletedAt.Valid {
+			p.DeletedAt = &deletedAt.Time
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+)
+
+func TestFieldConflictsInColumnOrder(t *testing.T) {
+	saved := config.TableMappings
+	defer func() { config.TableMappings = saved }()
+	config.TableMappings = []config.TableMapping{{
+		Table:      "product",
+		PrimaryKey: "uuid",
+		Columns: []config.Column{
+			{Name: "uuid"}, {Name: "product_name"}, {Name: "quantity"}, {Name: "price"},
+		},
+	}}
+
+	current := Product{UUID: "u-101", Version: 3}
+	fields := map[string]interface{}{"price": 5.0, "discount": true, "quantity": 2, "product_name": "Widget"}
+
+	var got []string
+	for _, c := range fieldConflicts(SourceSheet, 2, current, fields, "editor@example.com", ConflictReject) {
+		got = append(got, c.Field)
+	}
+	if want := []string{"product_name", "quantity", "price", "discount"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("conflicts on %v, want %v", got, want)
+	}
+}
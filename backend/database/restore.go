@@ -0,0 +1,311 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+)
+
+// OriginRestore tags transactions written by a point-in-time restore.
+const OriginRestore = "restore"
+
+// What a restore does to a row.
+const (
+	RestoreUpdate   = "update"   // change fields
+	RestoreDelete   = "delete"   // the row did not exist yet or was deleted
+	RestoreUndelete = "undelete" // take the row out of the trash
+	RestoreCreate   = "create"   // insert a row that was hard-deleted
+)
+
+// RestoreChange is what a point-in-time restore does to one row, with the
+// writable fields that differ from the target state.
+//
+// Version is the row's version the plan was made from, if the table has a
+// version column. Status and Error are set by ApplyRestore: EditApplied,
+// EditConflict if the row changed since, or EditFailed.
+type RestoreChange struct {
+	RowID   string         `json:"row_id"`
+	Action  string         `json:"action"`
+	Fields  []RestoreField `json:"fields,omitempty"`
+	Version *int64         `json:"version,omitempty"`
+	Status  string         `json:"status,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// RestoreField is a field's current value and the value it is restored to.
+// nil means NULL.
+type RestoreField struct {
+	Field   string  `json:"field"`
+	Current *string `json:"current"`
+	Target  *string `json:"target"`
+}
+
+func (c RestoreChange) String() string {
+	head := c.Action + " " + c.RowID
+	if c.Status != "" {
+		head += ": " + c.Status
+	}
+	if c.Error != "" {
+		head += " (" + c.Error + ")"
+	}
+	parts := []string{head}
+	for _, f := range c.Fields {
+		parts = append(parts, fmt.Sprintf("%s: %s -> %s", f.Field, restoreString(f.Current), restoreString(f.Target)))
+	}
+	return strings.Join(parts, "\n  ")
+}
+
+func restoreString(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", *v)
+}
+
+// ParseRestoreTime reads the time to restore to, either RFC 3339 or
+// TimeLayout in UTC.
+func ParseRestoreTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(TimeLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 2006-01-02T15:04:05Z or %q", s, TimeLayout)
+	}
+	return t, nil
+}
+
+// PlanRestore works out what it takes to bring a table, or only the row
+// rowID if it is set, back to how it was at the given time. Each field is
+// taken from the last change recorded in change_history up to then, or from
+// the first change after it; fields with no history keep their value.
+func PlanRestore(m config.TableMapping, at time.Time, rowID string) ([]RestoreChange, error) {
+	target, order, err := stateAt(m.Table, at, rowID)
+	if err != nil || len(order) == 0 {
+		return nil, err
+	}
+
+	current, err := currentRows(m, order)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RestoreChange
+	for _, id := range order {
+		want, have := target[id], current[id]
+
+		existed := value(want, have, m.PrimaryKey) != nil
+		if m.SoftDelete != "" && value(want, have, m.SoftDelete) != nil {
+			existed = false
+		}
+		exists := have != nil
+		if m.SoftDelete != "" && have[m.SoftDelete] != nil {
+			exists = false
+		}
+
+		change := RestoreChange{RowID: id, Action: RestoreUpdate, Version: rowVersion(have)}
+		switch {
+		case !existed:
+			if exists {
+				changes = append(changes, RestoreChange{RowID: id, Action: RestoreDelete, Version: change.Version})
+			}
+			continue
+		case have == nil:
+			change.Action = RestoreCreate
+		case !exists:
+			change.Action = RestoreUndelete
+		}
+
+		for _, name := range m.ColumnNames() {
+			t, ok := want[name]
+			if !ok || !m.Writable(name) {
+				continue
+			}
+			if cur := have[name]; !sameValue(cur, t) {
+				change.Fields = append(change.Fields, RestoreField{Field: name, Current: cur, Target: t})
+			}
+		}
+		if change.Action != RestoreUpdate || len(change.Fields) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// ApplyRestore writes a product restore plan through products, one product
+// at a time. Each write is checked against the version the plan was made
+// from, a product changed since is left alone and reported as a conflict.
+// The outcome of every change is set in its Status and Error. The changes
+// reach the sheet through the CDC listener like any other write.
+func ApplyRestore(products ProductRepository, m config.TableMapping, changes []RestoreChange) {
+	for i := range changes {
+		c := &changes[i]
+
+		var conflict *ConflictError
+		switch err := applyRestoreChange(products, m, *c); {
+		case err == nil:
+			c.Status = EditApplied
+		case errors.As(err, &conflict):
+			c.Status, c.Error = EditConflict, err.Error()
+		default:
+			c.Status, c.Error = EditFailed, err.Error()
+		}
+	}
+}
+
+func applyRestoreChange(products ProductRepository, m config.TableMapping, c RestoreChange) error {
+	w := ProductWrite{Origin: OriginRestore, Author: OriginRestore, BaseVersion: c.Version, Policy: ConflictReject}
+
+	fields := map[string]interface{}{}
+	for _, f := range c.Fields {
+		fields[f.Field] = restoreValue(m, f)
+	}
+
+	switch c.Action {
+	case RestoreDelete:
+		return products.Delete(c.RowID, w)
+
+	case RestoreCreate:
+		p := Product{UUID: c.RowID}
+		for name, v := range fields {
+			if err := setProductField(&p, name, v); err != nil {
+				return err
+			}
+		}
+		_, err := products.Create(p, w)
+		return err
+
+	case RestoreUndelete:
+		if err := products.Restore(c.RowID, w); err != nil {
+			return err
+		}
+		if w.BaseVersion != nil {
+			// Taking it out of the trash bumped the version.
+			next := *w.BaseVersion + 1
+			w.BaseVersion = &next
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	_, err := products.Patch(c.RowID, fields, w)
+	return err
+}
+
+// stateAt replays change_history up to at. It returns the recorded fields
+// of every row with history, and the row IDs in the order they first appear.
+func stateAt(table string, at time.Time, rowID string) (map[string]map[string]*string, []string, error) {
+	query := `
+		SELECT row_id, field, old_value, new_value, changed_at
+		FROM change_history
+		WHERE table_name = ? AND (? = '' OR row_id = ?)
+		ORDER BY changed_at, id
+	`
+	rows, err := DB.Query(query, table, rowID, rowID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	state := map[string]map[string]*string{}
+	var order []string
+	for rows.Next() {
+		var id, field string
+		var oldValue, newValue *string
+		var changedAt time.Time
+		if err := rows.Scan(&id, &field, &oldValue, &newValue, &changedAt); err != nil {
+			return nil, nil, err
+		}
+
+		fields, ok := state[id]
+		if !ok {
+			fields = map[string]*string{}
+			state[id] = fields
+			order = append(order, id)
+		}
+		if !changedAt.After(at) {
+			fields[field] = newValue
+		} else if _, seen := fields[field]; !seen {
+			// Changed only later, so it still had the value from before.
+			fields[field] = oldValue
+		}
+	}
+	return state, order, rows.Err()
+}
+
+// currentRows reads the given rows, soft-deleted ones included, with their
+// values rendered the way change_history stores them. Missing rows are nil.
+func currentRows(m config.TableMapping, ids []string) (map[string]map[string]*string, error) {
+	withDeleted := m
+	if m.SoftDelete != "" {
+		withDeleted.Columns = append(append([]config.Column{}, m.Columns...), config.Column{Name: m.SoftDelete})
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := queryTableRows(DB, withDeleted, fmt.Sprintf("WHERE `%s` IN (%s)", m.PrimaryKey, placeholders(len(ids))), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[string]map[string]*string{}
+	for _, row := range rows {
+		fields := map[string]*string{}
+		for name, v := range row {
+			if v != nil {
+				s := fmt.Sprintf("%v", v)
+				fields[name] = &s
+			} else {
+				fields[name] = nil
+			}
+		}
+		current[fmt.Sprintf("%v", row[m.PrimaryKey])] = fields
+	}
+	return current, nil
+}
+
+// value is a field's value in the target state, falling back to the
+// current one for fields without history.
+func value(target, current map[string]*string, field string) *string {
+	if v, ok := target[field]; ok {
+		return v
+	}
+	return current[field]
+}
+
+func sameValue(a, b *string) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// rowVersion is the version of a row as read by currentRows, nil for a
+// missing row or a table without a version column.
+func rowVersion(row map[string]*string) *int64 {
+	v := row["version"]
+	if v == nil {
+		return nil
+	}
+	n, err := strconv.ParseInt(*v, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// restoreValue converts a value from change_history back to one MySQL takes
+// for the column. Booleans are stored as true/false.
+func restoreValue(m config.TableMapping, f RestoreField) interface{} {
+	if f.Target == nil {
+		return nil
+	}
+	if c, ok := m.Column(f.Field); ok && c.Type == config.TypeBool {
+		return *f.Target == "true"
+	}
+	return *f.Target
+}
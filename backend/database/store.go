@@ -3,8 +3,10 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
 	_ "github.com/go-sql-driver/mysql"
 	"golang.org/x/oauth2"
 )
@@ -61,66 +63,9 @@ func SaveSheetID(name, spreadsheetID string) error {
 	return err
 }
 
-func GetAllProducts() ([]map[string]interface{}, error) {
-	rows, err := DB.Query("SELECT uuid, product_name, quantity, price, discount, last_updated_by FROM product")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var products []map[string]interface{}
-	for rows.Next() {
-		var uuid, name, lastUpdatedBy string
-		var qty int
-		var price float64
-		var discount bool
-
-		if err := rows.Scan(&uuid, &name, &qty, &price, &discount, &lastUpdatedBy); err != nil {
-			return nil, err
-		}
-
-		products = append(products, map[string]interface{}{
-			"uuid":            uuid,
-			"product_name":    name,
-			"quantity":        qty,
-			"price":           price,
-			"discount":        discount,
-			"last_updated_by": lastUpdatedBy,
-		})
-	}
-	return products, nil
-}
-
-func GetProductByUUID(uuid string) (map[string]interface{}, error) {
-	query := `
-        SELECT uuid, product_name, quantity, price, discount, last_updated_by 
-        FROM product WHERE uuid = ?
-    `
-
-	var name, lastUpdatedBy string
-	var qty int
-	var price float64
-	var discount bool
-
-	err := DB.QueryRow(query, uuid).Scan(&uuid, &name, &qty, &price, &discount, &lastUpdatedBy)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("product not found")
-		}
-		return nil, err
-	}
-
-	return map[string]interface{}{
-		"uuid":            uuid,
-		"product_name":    name,
-		"quantity":        qty,
-		"price":           price,
-		"discount":        discount,
-		"last_updated_by": lastUpdatedBy,
-	}, nil
-}
-
-func GetMasterStatus() (string, uint32, error) {
+// GetMasterStatus returns the current binlog file, position and executed
+// GTID set. The GTID set is empty when the server does not report one.
+func GetMasterStatus() (string, uint32, string, error) {
 	var file string
 	var position uint32
 	var binlogDoDB, binlogIgnoreDB, executedGtidSet interface{}
@@ -131,10 +76,21 @@ func GetMasterStatus() (string, uint32, error) {
 	if err != nil {
 		err = row.Scan(&file, &position, &binlogDoDB, &binlogIgnoreDB)
 		if err != nil {
-			return "", 0, fmt.Errorf("failed to get master status: %v", err)
+			return "", 0, "", fmt.Errorf("failed to get master status: %v", err)
 		}
 	}
-	return file, position, nil
+
+	var gtidSet string
+	switch v := executedGtidSet.(type) {
+	case []byte:
+		gtidSet = string(v)
+	case string:
+		gtidSet = v
+	}
+	// MySQL wraps long sets over several lines.
+	gtidSet = strings.ReplaceAll(gtidSet, "\n", "")
+
+	return file, position, gtidSet, nil
 }
 
 func GetSheetID(name string) (string, error) {
@@ -170,71 +126,9 @@ func GetLatestToken() (*oauth2.Token, error) {
 	}, nil
 }
 
-func UpdateProductField(uuid string, dbField string, value interface{}, userEmail string) error {
-
-	allowedFields := map[string]bool{
-		"product_name": true,
-		"quantity":     true,
-		"price":        true,
-		"discount":     true,
-	}
-
-	if !allowedFields[dbField] {
-		return fmt.Errorf("invalid database field: %s", dbField)
-	}
-
-	query := fmt.Sprintf("UPDATE product SET %s = ?, last_updated_by = ?, updated_at = ? WHERE uuid = ?", dbField)
-
-	_, err := DB.Exec(query, value, userEmail, time.Now(), uuid)
-	return err
-}
-
-func TxUpsertProductField(tx *sql.Tx, uuid string, dbField string, value interface{}, userEmail string) error {
-	allowedFields := map[string]bool{
-		"product_name": true,
-		"quantity":     true,
-		"price":        true,
-		"discount":     true,
-	}
-
-	if !allowedFields[dbField] {
-		return fmt.Errorf("invalid database field: %s", dbField)
-	}
-
-	var query string
-
-	switch dbField {
-	case "product_name":
-		query = `
-			INSERT INTO product (uuid, product_name, price, last_updated_by, updated_at) 
-			VALUES (?, ?, 0.00, ?, ?) 
-			ON DUPLICATE KEY UPDATE 
-				product_name = VALUES(product_name), 
-				last_updated_by = VALUES(last_updated_by), 
-				updated_at = VALUES(updated_at)
-		`
-
-	case "price":
-		query = `
-			INSERT INTO product (uuid, price, product_name, last_updated_by, updated_at) 
-			VALUES (?, ?, 'New Product', ?, ?) 
-			ON DUPLICATE KEY UPDATE 
-				price = VALUES(price), 
-				last_updated_by = VALUES(last_updated_by), 
-				updated_at = VALUES(updated_at)
-		`
-
-	default:
-		query = fmt.Sprintf(`
-			INSERT INTO product (uuid, %s, product_name, price, last_updated_by, updated_at) 
-			VALUES (?, ?, 'New Product', 0.00, ?, ?) 
-			ON DUPLICATE KEY UPDATE 
-				%s = VALUES(%s), 
-				last_updated_by = VALUES(last_updated_by), 
-				updated_at = VALUES(updated_at)
-		`, dbField, dbField, dbField)
-	}
-	_, err := tx.Exec(query, uuid, value, userEmail, time.Now())
-
-	return err
+// ProductFieldWritable reports whether the product column may be written,
+// according to the product table's column mapping.
+func ProductFieldWritable(dbField string) bool {
+	m, ok := config.MappingForTable("product")
+	return ok && m.Writable(dbField)
 }
@@ -0,0 +1,316 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+)
+
+// TimeLayout is how DATETIME and TIMESTAMP values are written to the sheet.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// SaveTableMapping registers a table to tab mapping in sheet_mappings, keyed
+// by spreadsheet and table name.
+func SaveTableMapping(spreadsheetID string, m config.TableMapping) error {
+	columns, err := json.Marshal(m.Columns)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO sheet_mappings (name, spreadsheet_id, table_name, primary_key, tab_name, columns_json, soft_delete_column)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			name = VALUES(name),
+			primary_key = VALUES(primary_key),
+			tab_name = VALUES(tab_name),
+			columns_json = VALUES(columns_json),
+			soft_delete_column = VALUES(soft_delete_column)
+	`
+	name := spreadsheetID + "/" + m.Table
+	_, err = DB.Exec(query, name, spreadsheetID, m.Table, m.PrimaryKey, m.Tab, columns, m.SoftDelete)
+	if err != nil {
+		return fmt.Errorf("failed to register mapping for %s: %w", m.Table, err)
+	}
+	return nil
+}
+
+// RemoveTableMappings unregisters the tables of a spreadsheet that are not
+// in keep, i.e. no longer in the sync config.
+func RemoveTableMappings(spreadsheetID string, keep []config.TableMapping) error {
+	query := "DELETE FROM sheet_mappings WHERE spreadsheet_id = ? AND table_name IS NOT NULL"
+	args := []interface{}{spreadsheetID}
+	if len(keep) > 0 {
+		query += fmt.Sprintf(" AND table_name NOT IN (%s)", placeholders(len(keep)))
+		for _, m := range keep {
+			args = append(args, m.Table)
+		}
+	}
+
+	res, err := DB.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to remove old table mappings: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Removed %d table mapping(s) no longer in the sync config", n)
+	}
+	return nil
+}
+
+// GetTableMappings returns the table mappings registered for a spreadsheet.
+func GetTableMappings(spreadsheetID string) ([]config.TableMapping, error) {
+	query := `
+		SELECT table_name, primary_key, tab_name, columns_json, COALESCE(soft_delete_column, '')
+		FROM sheet_mappings
+		WHERE spreadsheet_id = ? AND table_name IS NOT NULL
+		ORDER BY id
+	`
+	rows, err := DB.Query(query, spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []config.TableMapping
+	for rows.Next() {
+		var m config.TableMapping
+		var columns []byte
+
+		if err := rows.Scan(&m.Table, &m.PrimaryKey, &m.Tab, &columns, &m.SoftDelete); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(columns, &m.Columns); err != nil {
+			return nil, fmt.Errorf("bad column list for %s: %w", m.Table, err)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// MarkReconciled records that a table's tab in a spreadsheet was fully
+// reconciled with the table contents as of at.
+func MarkReconciled(spreadsheetID, table string, at time.Time) error {
+	_, err := DB.Exec("UPDATE sheet_mappings SET last_reconciled_at = ? WHERE spreadsheet_id = ? AND table_name = ?", at, spreadsheetID, table)
+	return err
+}
+
+// LastReconciled returns when a table was last reconciled with its tab in a
+// spreadsheet, or the zero time.
+func LastReconciled(spreadsheetID, table string) (time.Time, error) {
+	var at sql.NullTime
+	err := DB.QueryRow("SELECT last_reconciled_at FROM sheet_mappings WHERE spreadsheet_id = ? AND table_name = ?", spreadsheetID, table).Scan(&at)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return at.Time, nil
+}
+
+// GetTableRows returns every row of a mapped table, keyed by column name,
+// with values converted the same way the CDC listener converts binlog rows.
+// Soft-deleted rows are left out.
+func GetTableRows(m config.TableMapping) ([]map[string]interface{}, error) {
+	return queryTableRows(DB, m, "WHERE "+notDeleted(m))
+}
+
+// GetTableRow returns a single row by primary key, or nil if it does not
+// exist or is soft-deleted.
+func GetTableRow(m config.TableMapping, id string) (map[string]interface{}, error) {
+	rows, err := queryTableRows(DB, m, fmt.Sprintf("WHERE `%s` = ? AND %s", m.PrimaryKey, notDeleted(m)), id)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+// UpdateTableRow sets the given columns of a row, identified by primary key,
+// in a transaction tagged with origin. Only columns the mapping marks
+// writable are accepted.
+func UpdateTableRow(m config.TableMapping, id string, fields map[string]interface{}, origin string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var sets []string
+	var args []interface{}
+	for _, name := range m.ColumnNames() {
+		val, ok := fields[name]
+		if !ok {
+			continue
+		}
+		if !m.Writable(name) {
+			return fmt.Errorf("column %s of %s is not writable", name, m.Table)
+		}
+		sets = append(sets, fmt.Sprintf("`%s` = ?", name))
+		args = append(args, val)
+	}
+	if len(sets) != len(fields) {
+		return fmt.Errorf("unknown column in update of %s", m.Table)
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TxMarkOrigin(tx, origin); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE `%s` SET %s WHERE `%s` = ?", m.Table, strings.Join(sets, ", "), m.PrimaryKey)
+	if _, err := tx.Exec(query, append(args, id)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRemovedRows deletes rows that were removed from the sheet, softly if
+// the table supports it. The transaction is tagged as coming from the sheet,
+// which no longer has them.
+func DeleteRemovedRows(m config.TableMapping, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TxMarkOrigin(tx, OriginSheet); err != nil {
+		return err
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE `%s` IN (%s)", m.Table, m.PrimaryKey, placeholders(len(ids)))
+	if m.SoftDelete != "" {
+		query = fmt.Sprintf("UPDATE `%s` SET `%s` = CURRENT_TIMESTAMP WHERE `%s` IN (%s) AND %s",
+			m.Table, m.SoftDelete, m.PrimaryKey, placeholders(len(ids)), notDeleted(m))
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// notDeleted is the condition matching rows of m that are not soft-deleted.
+func notDeleted(m config.TableMapping) string {
+	if m.SoftDelete == "" {
+		return "TRUE"
+	}
+	return fmt.Sprintf("`%s` IS NULL", m.SoftDelete)
+}
+
+// queryer and execer are satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func queryTableRows(q queryer, m config.TableMapping, where string, args ...interface{}) ([]map[string]interface{}, error) {
+	types, err := columnTypes(m.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	names := m.ColumnNames()
+	query := fmt.Sprintf("SELECT `%s` FROM `%s` %s", strings.Join(names, "`, `"), m.Table, where)
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(names))
+		dest := make([]interface{}, len(names))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			row[name] = normalizeValue(types[name], values[i])
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// columnTypes returns the full COLUMN_TYPE (e.g. "tinyint(1)", "decimal(10,2)")
+// of every column in table.
+func columnTypes(table string) (map[string]string, error) {
+	query := `
+		SELECT COLUMN_NAME, COLUMN_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`
+	rows, err := DB.Query(query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var name, colType string
+		if err := rows.Scan(&name, &colType); err != nil {
+			return nil, err
+		}
+		types[name] = strings.ToLower(colType)
+	}
+	return types, rows.Err()
+}
+
+func normalizeValue(colType string, val interface{}) interface{} {
+	if t, ok := val.(time.Time); ok {
+		return t.UTC().Format(TimeLayout)
+	}
+
+	var s string
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case []byte:
+		s = string(v)
+	case int64:
+		if strings.HasPrefix(colType, "tinyint(1)") {
+			return v != 0
+		}
+		return v
+	default:
+		return v
+	}
+
+	switch {
+	case strings.HasPrefix(colType, "tinyint(1)"):
+		return s != "0"
+	case strings.HasPrefix(colType, "decimal"), strings.HasPrefix(colType, "float"), strings.HasPrefix(colType, "double"):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case strings.Contains(colType, "int"):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	}
+	return s
+}
@@ -0,0 +1,223 @@
+package e2e
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+)
+
+// The tables as canal describes them, with the columns of init.sql.
+var (
+	productTable = &schema.Table{
+		Schema: "interndb",
+		Name:   "product",
+		Columns: []schema.TableColumn{
+			{Name: "uuid", Type: schema.TYPE_STRING, RawType: "varchar(36)"},
+			{Name: "product_name", Type: schema.TYPE_STRING, RawType: "varchar(255)"},
+			{Name: "quantity", Type: schema.TYPE_NUMBER, RawType: "int"},
+			{Name: "price", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"},
+			{Name: "discount", Type: schema.TYPE_NUMBER, RawType: "tinyint(1)"},
+			{Name: "updated_at", Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"},
+			{Name: "last_updated_by", Type: schema.TYPE_STRING, RawType: "varchar(50)"},
+			{Name: "version", Type: schema.TYPE_NUMBER, RawType: "int unsigned", IsUnsigned: true},
+			{Name: "deleted_at", Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"},
+		},
+		PKColumns: []int{0},
+	}
+
+	originTable = &schema.Table{
+		Schema: "interndb",
+		Name:   "sync_origin",
+		Columns: []schema.TableColumn{
+			{Name: "origin", Type: schema.TYPE_STRING, RawType: "varchar(32)"},
+			{Name: "txn_count", Type: schema.TYPE_NUMBER, RawType: "bigint unsigned", IsUnsigned: true},
+			{Name: "updated_at", Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"},
+		},
+		PKColumns: []int{0},
+	}
+)
+
+// Binlog stands in for MySQL's row based binlog of the product table. Each
+// write to the repository is logged by Commit as a transaction of row
+// events, found by comparing the products with how they were at the last
+// commit, the same events MySQL logs for the committed rows.
+type Binlog struct {
+	File string
+
+	txs     []binlogTx
+	pos     uint32
+	last    map[string]database.Product
+	origins map[string]uint64
+}
+
+type binlogTx struct {
+	events []*canal.RowsEvent
+	// end is the position after the transaction's XID event.
+	end mysql.Position
+}
+
+// binlogStart is where the first event of a binlog file is.
+const binlogStart = 4
+
+// NewBinlog starts a binlog after products, the rows that already exist.
+func NewBinlog(products []database.Product) *Binlog {
+	b := &Binlog{File: "mysql-bin.000001", pos: binlogStart, last: map[string]database.Product{}, origins: map[string]uint64{}}
+	for _, p := range products {
+		b.last[p.UUID] = p
+	}
+	return b
+}
+
+// Start is the position of the first transaction.
+func (b *Binlog) Start() mysql.Position {
+	return mysql.Position{Name: b.File, Pos: binlogStart}
+}
+
+// Commit logs the changes made to products since the last commit as one
+// transaction tagged with origin, or untagged for OriginSQL, and returns the
+// IDs of the rows changed. Nothing is logged if no row changed.
+func (b *Binlog) Commit(products []database.Product, origin string) []string {
+	now := time.Now()
+
+	sort.Slice(products, func(i, j int) bool { return products[i].UUID < products[j].UUID })
+
+	var inserts, updates [][]interface{}
+	var changed []string
+	seen := map[string]bool{}
+	for _, p := range products {
+		seen[p.UUID] = true
+		old, ok := b.last[p.UUID]
+		switch {
+		case !ok:
+			inserts = append(inserts, productImage(p))
+		case !sameProduct(old, p):
+			updates = append(updates, productImage(old), productImage(p))
+		default:
+			continue
+		}
+		changed = append(changed, p.UUID)
+	}
+	var deletes [][]interface{}
+	for id, p := range b.last {
+		if !seen[id] {
+			deletes = append(deletes, productImage(p))
+			changed = append(changed, id)
+		}
+	}
+	sort.Slice(deletes, func(i, j int) bool { return deletes[i][0].(string) < deletes[j][0].(string) })
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	var tx binlogTx
+	if origin != "" && origin != database.OriginSQL {
+		b.origins[origin]++
+		n := b.origins[origin]
+		after := []interface{}{origin, n, now}
+		if n == 1 {
+			tx.events = append(tx.events, b.event(originTable, canal.InsertAction, now, after))
+		} else {
+			tx.events = append(tx.events, b.event(originTable, canal.UpdateAction, now, []interface{}{origin, n - 1, now}, after))
+		}
+	}
+	if len(inserts) > 0 {
+		tx.events = append(tx.events, b.event(productTable, canal.InsertAction, now, inserts...))
+	}
+	if len(updates) > 0 {
+		tx.events = append(tx.events, b.event(productTable, canal.UpdateAction, now, updates...))
+	}
+	if len(deletes) > 0 {
+		tx.events = append(tx.events, b.event(productTable, canal.DeleteAction, now, deletes...))
+	}
+
+	// The XID event.
+	b.pos += 31
+	tx.end = mysql.Position{Name: b.File, Pos: b.pos}
+	b.txs = append(b.txs, tx)
+
+	b.last = make(map[string]database.Product, len(products))
+	for _, p := range products {
+		b.last[p.UUID] = p
+	}
+	return changed
+}
+
+func (b *Binlog) event(table *schema.Table, action string, at time.Time, rows ...[]interface{}) *canal.RowsEvent {
+	// Roughly the size of a rows event, positions only need to grow.
+	b.pos += uint32(50 + 40*len(rows))
+	return &canal.RowsEvent{
+		Table:  table,
+		Action: action,
+		Rows:   rows,
+		Header: &replication.EventHeader{Timestamp: uint32(at.Unix()), LogPos: b.pos},
+	}
+}
+
+// Replay feeds h every transaction after from the way canal does and
+// returns the position reached.
+func (b *Binlog) Replay(h *cdc.MyEventHandler, from mysql.Position) (mysql.Position, error) {
+	for _, tx := range b.txs {
+		if tx.end.Compare(from) <= 0 {
+			continue
+		}
+		for _, e := range tx.events {
+			if err := h.OnRow(e); err != nil {
+				return from, err
+			}
+		}
+		header := &replication.EventHeader{LogPos: tx.end.Pos}
+		if err := h.OnXID(header, tx.end); err != nil {
+			return from, err
+		}
+		if err := h.OnPosSynced(header, tx.end, nil, false); err != nil {
+			return from, err
+		}
+		from = tx.end
+	}
+	return from, nil
+}
+
+// Rotate tells h which file it is reading, as canal does when it starts.
+func (b *Binlog) Rotate(h *cdc.MyEventHandler) error {
+	return h.OnRotate(&replication.EventHeader{}, &replication.RotateEvent{Position: binlogStart, NextLogName: []byte(b.File)})
+}
+
+// productImage is a product row as canal decodes it.
+func productImage(p database.Product) []interface{} {
+	discount := int8(0)
+	if p.Discount {
+		discount = 1
+	}
+	var deletedAt interface{}
+	if p.DeletedAt != nil {
+		deletedAt = p.DeletedAt.Truncate(time.Second)
+	}
+	return []interface{}{
+		p.UUID, p.ProductName, int32(p.Quantity), p.Price, discount,
+		p.UpdatedAt.Truncate(time.Second), p.LastUpdatedBy, uint32(p.Version), deletedAt,
+	}
+}
+
+// sameProduct compares products the way the binlog sees them, timestamps
+// to the second.
+func sameProduct(a, b database.Product) bool {
+	ai, bi := productImage(a), productImage(b)
+	for i := range ai {
+		if ai[i] != bi[i] {
+			if ta, ok := ai[i].(time.Time); ok {
+				if tb, ok := bi[i].(time.Time); ok && ta.Equal(tb) {
+					continue
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
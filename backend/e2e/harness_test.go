@@ -0,0 +1,525 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets/gsheetstest"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/handlers"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/worker"
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// Harness runs the whole sync without MySQL or Google. Products are kept in
+// a MemoryProducts, every write to it is logged to a Binlog which is fed to
+// the CDC listener, and the sync worker writes to a FakeSheets server. Edits
+// in the sheet are typed into the fake and sent to the webhook handler the
+// way the Apps Script does.
+type Harness struct {
+	Mapping  config.TableMapping
+	Products *database.MemoryProducts
+	Binlog   *Binlog
+	Sheets   *gsheetstest.FakeSheets
+	History  *History
+	// OldScript makes Type send the UUID and Updated By cells of new rows
+	// too, like copies of the Apps Script from before it skipped read-only
+	// columns.
+	OldScript bool
+
+	sm          *gsheets.SheetManager
+	annotations *database.MemoryAnnotations
+	listener    *cdc.MyEventHandler
+	events      chan cdc.SyncEvent
+	feedback    chan gsheets.Feedback
+
+	worker  *worker.Worker
+	stop    context.CancelFunc
+	stopped chan struct{}
+	// errs are what went wrong in the worker since the last Sync.
+	errs []error
+
+	// read is how far the listener has read the binlog, checkpoint the
+	// position the worker saved last.
+	read       mysql.Position
+	checkpoint mysql.Position
+
+	deliveries int
+	newRows    int
+
+	// last is the last webhook request, for Redeliver.
+	last     *http.Request
+	lastBody []byte
+}
+
+// New starts a harness for the product mapping with the given products in
+// the table. The sheet starts out empty and gets them from a full sync.
+func New(mapping config.TableMapping, products ...database.Product) (*Harness, error) {
+	h := &Harness{
+		Mapping:  mapping,
+		Products: database.NewMemoryProducts(products...),
+		Sheets:   gsheetstest.NewFakeSheets(mapping.Tab),
+		History:  &History{},
+
+		annotations: database.NewMemoryAnnotations(),
+		feedback:    make(chan gsheets.Feedback, 100),
+	}
+	handlers.Products = h.Products
+
+	h.Binlog = NewBinlog(h.all())
+	h.checkpoint = h.Binlog.Start()
+
+	if err := h.start(true); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Harness) Close() {
+	h.stopWorker()
+	h.Sheets.Close()
+}
+
+// start does what main does when the process starts: the listener resumes
+// from the checkpoint and the worker runs with a new SheetManager, which
+// knows nothing about row positions yet. With snapshot the sheet is fully
+// synced first. The outbox and drift checks are left out, a batch the
+// sheet does not take fails the next Sync.
+func (h *Harness) start(snapshot bool) error {
+	h.events = make(chan cdc.SyncEvent, 10000)
+	h.listener = &cdc.MyEventHandler{
+		OutChan: h.events,
+		Keys:    map[string]string{h.Mapping.Table: h.Mapping.PrimaryKey},
+		History: h.History.Record,
+	}
+	if h.Mapping.SoftDelete != "" {
+		h.listener.SoftDelete = map[string]string{h.Mapping.Table: h.Mapping.SoftDelete}
+	}
+	if err := h.Binlog.Rotate(h.listener); err != nil {
+		return err
+	}
+	h.read = h.checkpoint
+
+	w := worker.New(h.events, h.feedback)
+	w.Connect = func() (*gsheets.SheetManager, error) {
+		sm, err := h.Sheets.SheetManager([]config.TableMapping{h.Mapping})
+		if err != nil {
+			h.errs = append(h.errs, err)
+			return nil, err
+		}
+		// Like sheet_annotations, what was annotated outlives the process.
+		sm.Annotations = h.annotations
+		h.sm = sm
+		return sm, nil
+	}
+	w.Snapshot = snapshot
+	w.FullSync = func(sm *gsheets.SheetManager) {
+		if _, err := sm.Reconcile(h.Mapping.Table, h.rows()); err != nil {
+			h.errs = append(h.errs, fmt.Errorf("full sync: %w", err))
+		}
+	}
+	w.DrainOutbox = func(*gsheets.SheetManager) {}
+	w.ReplayOutbox = func(*gsheets.SheetManager) {}
+	w.Drift = func(*gsheets.SheetManager) {}
+	w.Queue = func(events []cdc.SyncEvent, cause error) []cdc.SyncEvent {
+		h.errs = append(h.errs, fmt.Errorf("syncing batch: %w", cause))
+		return nil
+	}
+	w.SaveCheckpoint = func(checkpoint cdc.SyncEvent) error {
+		h.checkpoint = checkpoint.Position
+		return nil
+	}
+	// Batches are flushed by Sync, never by a timer.
+	w.BatchWindow, w.RetryInterval, w.DriftInterval = time.Hour, time.Hour, time.Hour
+
+	ctx, stop := context.WithCancel(context.Background())
+	h.worker, h.stop, h.stopped = w, stop, make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(h.stopped)
+	}()
+	return nil
+}
+
+func (h *Harness) stopWorker() {
+	if h.stop != nil {
+		h.stop()
+		<-h.stopped
+		h.stop = nil
+	}
+}
+
+// Sync lets the listener read the binlog to its end and the worker flush
+// everything it was sent.
+func (h *Harness) Sync() error {
+	if err := h.readBinlog(); err != nil {
+		return err
+	}
+	h.worker.Sync()
+
+	err := errors.Join(h.errs...)
+	h.errs = nil
+	return err
+}
+
+func (h *Harness) readBinlog() error {
+	pos, err := h.Binlog.Replay(h.listener, h.read)
+	h.read = pos
+	return err
+}
+
+// Restart stops and starts the sync. With crash the process dies after the
+// listener read the binlog but before the worker wrote anything, so what
+// it read is lost and read again from the checkpoint.
+func (h *Harness) Restart(crash bool) error {
+	if crash {
+		h.stopWorker()
+		if err := h.readBinlog(); err != nil {
+			return err
+		}
+		for len(h.events) > 0 {
+			<-h.events
+		}
+		for len(h.feedback) > 0 {
+			<-h.feedback
+		}
+	} else {
+		if err := h.Sync(); err != nil {
+			return err
+		}
+		h.stopWorker()
+	}
+	return h.start(false)
+}
+
+// all returns every product, deleted ones included.
+func (h *Harness) all() []database.Product {
+	live, _ := h.Products.List(false)
+	trash, _ := h.Products.List(true)
+	return append(live, trash...)
+}
+
+// rows returns the products that belong in the sheet.
+func (h *Harness) rows() []map[string]interface{} {
+	live, _ := h.Products.List(false)
+	rows := make([]map[string]interface{}, len(live))
+	for i, p := range live {
+		rows[i] = p.Row()
+	}
+	return rows
+}
+
+// commit logs what the last request wrote.
+func (h *Harness) commit(origin string) {
+	h.Binlog.Commit(h.all(), origin)
+}
+
+// REST sends a request to the product API.
+func (h *Harness) REST(method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+
+	switch {
+	case path == "/api/products" && method == http.MethodGet:
+		handlers.GetProductsHandler(w, r)
+	case path == "/api/products" && method == http.MethodPost:
+		handlers.CreateProductHandler(w, r)
+	case path == "/api/products/trash" && method == http.MethodGet:
+		handlers.ListTrashHandler(w, r)
+	case strings.HasSuffix(path, "/restore") && method == http.MethodPost:
+		handlers.RestoreProductHandler(w, r)
+	case method == http.MethodPut:
+		handlers.UpdateProductHandler(w, r)
+	case method == http.MethodDelete:
+		handlers.DeleteProductHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+
+	h.commit(database.OriginAPI)
+	return w
+}
+
+// Webhook sends a batch to the webhook handler as a delivery of the given
+// ID.
+func (h *Harness) Webhook(payloads []handlers.SheetUpdatePayload, deliveryID string, atomic bool) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payloads)
+	target := "/api/webhook/sheets"
+	if atomic {
+		target += "?atomic=true"
+	}
+	r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	r.Header.Set(handlers.DeliveryIDHeader, deliveryID)
+	h.last, h.lastBody = r, body
+	return h.send(r)
+}
+
+// Redeliver sends the last webhook request again, as the Apps Script does
+// when it got no answer.
+func (h *Harness) Redeliver() *httptest.ResponseRecorder {
+	r := h.last.Clone(h.last.Context())
+	r.Body = io.NopCloser(bytes.NewReader(h.lastBody))
+	return h.send(r)
+}
+
+func (h *Harness) send(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handlers.SheetWebhookHandler(w, r, h.feedback)
+	h.commit(database.OriginSheet)
+	return w
+}
+
+// CellEdit is a value typed into the sheet. An empty UUID types it into a
+// new row at the bottom.
+type CellEdit struct {
+	UUID   string
+	Header string
+	Value  interface{}
+}
+
+// Type types edits into the sheet and sends them like the Apps Script's
+// onEdit: each edit with the row's Version, new rows get a UUID and all
+// their cells but the read-only ones sent with Price and Quantity set to 0. Like the script, it
+// copies the versions in the response to the Version column. It returns
+// the UUIDs of the new rows.
+func (h *Harness) Type(atomic bool, edits ...CellEdit) (*httptest.ResponseRecorder, []string, error) {
+	var payloads []handlers.SheetUpdatePayload
+	var created []string
+
+	for _, e := range edits {
+		col := h.column(e.Header)
+		if col == -1 {
+			return nil, nil, fmt.Errorf("no column %q", e.Header)
+		}
+
+		if e.UUID == "" {
+			h.newRows++
+			e.UUID = fmt.Sprintf("u-new-%d", h.newRows)
+			created = append(created, e.UUID)
+
+			row := len(h.Sheets.Values(h.Mapping.Tab))
+			values := make(map[string]interface{}, len(h.Mapping.Columns))
+			for _, c := range h.Mapping.Columns {
+				values[c.Header] = ""
+			}
+			values["UUID"], values["Price"], values["Quantity"] = e.UUID, 0, 0
+			values[e.Header] = e.Value
+			for i, c := range h.Mapping.Columns {
+				h.Sheets.SetCell(h.Mapping.Tab, row, i, values[c.Header])
+				if !c.ReadOnly || (h.OldScript && c.Header != "Last Updated" && c.Header != "Version") {
+					payloads = append(payloads, handlers.SheetUpdatePayload{UUID: e.UUID, Field: c.Header, Value: values[c.Header], UserEmail: "editor@example.com"})
+				}
+			}
+			continue
+		}
+
+		row := h.sheetRow(e.UUID)
+		if row == -1 {
+			return nil, nil, fmt.Errorf("%s is not in the sheet", e.UUID)
+		}
+		h.Sheets.SetCell(h.Mapping.Tab, row, col, e.Value)
+		payloads = append(payloads, handlers.SheetUpdatePayload{
+			UUID: e.UUID, Field: e.Header, Value: e.Value, UserEmail: "editor@example.com", Version: h.sheetVersion(row),
+		})
+	}
+
+	h.deliveries++
+	w := h.Webhook(payloads, fmt.Sprintf("delivery-%d", h.deliveries), atomic)
+	if w.Code != http.StatusOK {
+		return w, created, nil
+	}
+
+	var resp handlers.SheetWebhookResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return w, created, fmt.Errorf("webhook response: %w", err)
+	}
+	if version := h.column("Version"); version != -1 {
+		for id, v := range resp.Versions {
+			if row := h.sheetRow(id); row != -1 {
+				h.Sheets.SetCell(h.Mapping.Tab, row, version, float64(v))
+			}
+		}
+	}
+	return w, created, nil
+}
+
+// DeleteRows deletes rows in the sheet and sends them like the Apps
+// Script's onChange does.
+func (h *Harness) DeleteRows(uuids ...string) (*httptest.ResponseRecorder, error) {
+	var payloads []handlers.SheetUpdatePayload
+	for _, id := range uuids {
+		row := h.sheetRow(id)
+		if row == -1 {
+			return nil, fmt.Errorf("%s is not in the sheet", id)
+		}
+		h.Sheets.RemoveRow(h.Mapping.Tab, row)
+		payloads = append(payloads, handlers.SheetUpdatePayload{UUID: id, Action: handlers.ActionDelete, UserEmail: "editor@example.com"})
+	}
+
+	h.deliveries++
+	return h.Webhook(payloads, fmt.Sprintf("delivery-%d", h.deliveries), false), nil
+}
+
+func (h *Harness) column(header string) int {
+	for i, c := range h.Mapping.Columns {
+		if c.Header == header {
+			return i
+		}
+	}
+	return -1
+}
+
+// sheetRow returns the zero-based row showing id, or -1.
+func (h *Harness) sheetRow(id string) int {
+	for i, row := range h.Sheets.Values(h.Mapping.Tab) {
+		if i > 0 && len(row) > 0 && fmt.Sprintf("%v", row[0]) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Harness) sheetVersion(row int) *int64 {
+	col := h.column("Version")
+	values := h.Sheets.Values(h.Mapping.Tab)
+	if col == -1 || col >= len(values[row]) {
+		return nil
+	}
+	if v, ok := values[row][col].(float64); ok {
+		n := int64(v)
+		return &n
+	}
+	return nil
+}
+
+// Cell returns what the sheet shows for a product's column, nil if the row
+// is not in the sheet.
+func (h *Harness) Cell(id, header string) interface{} {
+	row, col := h.sheetRow(id), h.column(header)
+	if row == -1 || col == -1 {
+		return nil
+	}
+	values := h.Sheets.Values(h.Mapping.Tab)[row]
+	if col >= len(values) {
+		return ""
+	}
+	return values[col]
+}
+
+// Note returns the note on a product's cell.
+func (h *Harness) Note(id, header string) string {
+	return h.Sheets.Note(h.Mapping.Tab, h.sheetRow(id), h.column(header))
+}
+
+// SetNote puts a note on a product's cell the way a user does.
+func (h *Harness) SetNote(id, header, note string) {
+	h.Sheets.SetNote(h.Mapping.Tab, h.sheetRow(id), h.column(header), note)
+}
+
+// Check syncs, then compares the sheet with the products. Every product
+// must be in the sheet once with all its values, and nothing else.
+func (h *Harness) Check() error {
+	if err := h.Sync(); err != nil {
+		return err
+	}
+
+	values := h.Sheets.Values(h.Mapping.Tab)
+	if len(values) == 0 {
+		return fmt.Errorf("sheet has no header")
+	}
+	for i, c := range h.Mapping.Columns {
+		if i >= len(values[0]) || values[0][i] != c.Header {
+			return fmt.Errorf("header %d is %v, expected %q", i+1, cellAt(values[0], i), c.Header)
+		}
+	}
+
+	_, duplicates, err := h.sm.ReadRows(h.Mapping.Table)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, d := range duplicates {
+		problems = append(problems, fmt.Sprintf("%v is in the sheet more than once (row %d)", d.Values[0], d.Index+1))
+	}
+
+	drifts, err := h.sm.Diff(h.Mapping.Table, h.rows())
+	if err != nil {
+		return err
+	}
+	for _, d := range drifts {
+		if d.Kind != database.DriftMismatch {
+			problems = append(problems, fmt.Sprintf("%s: %s", d.RowID, d.Kind))
+			continue
+		}
+		for _, field := range d.Fields {
+			problems = append(problems, fmt.Sprintf("%s %s: database %v, sheet %v", d.RowID, field, d.DB[field], d.Sheet[field]))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("sheet does not match the database:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func cellAt(cells []interface{}, i int) interface{} {
+	if i < len(cells) {
+		return cells[i]
+	}
+	return nil
+}
+
+// History keeps the change history the listener records. Like the
+// change_history table it ignores changes already recorded at the same
+// binlog position.
+type History struct {
+	Changes []database.Change
+	seen    map[string]bool
+}
+
+func (h *History) Record(changes []database.Change) error {
+	if h.seen == nil {
+		h.seen = map[string]bool{}
+	}
+	for _, c := range changes {
+		key := fmt.Sprintf("%s/%d/%s/%s/%s", c.BinlogFile, c.BinlogPos, c.Table, c.RowID, c.Field)
+		if h.seen[key] {
+			continue
+		}
+		h.seen[key] = true
+		h.Changes = append(h.Changes, c)
+	}
+	return nil
+}
+
+// Of returns the changes recorded for a row, oldest first.
+func (h *History) Of(rowID string) []database.Change {
+	var changes []database.Change
+	for _, c := range h.Changes {
+		if c.RowID == rowID {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
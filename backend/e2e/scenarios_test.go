@@ -0,0 +1,422 @@
+package e2e
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/handlers"
+)
+
+// Seed is what the product table holds when a scenario starts.
+func Seed() []database.Product {
+	at := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	return []database.Product{
+		{UUID: "u-101", ProductName: "Widget", Quantity: 10, Price: 9.99, UpdatedAt: at, LastUpdatedBy: "system"},
+		{UUID: "u-102", ProductName: "Gadget", Quantity: 5, Price: 24.5, Discount: true, UpdatedAt: at, LastUpdatedBy: "system"},
+		{UUID: "u-103", ProductName: "Gizmo", Quantity: 0, Price: 3, UpdatedAt: at, LastUpdatedBy: "system"},
+	}
+}
+
+// The scenarios cover REST and sheet edits, their conflicts and restarts of
+// the sync. Each fails with the first thing that isn't as expected.
+func TestREST(t *testing.T)     { run(t, restScenario) }
+func TestWebhook(t *testing.T)  { run(t, webhookScenario) }
+func TestConflict(t *testing.T) { run(t, conflictScenario) }
+func TestRestart(t *testing.T)  { run(t, restartScenario) }
+func TestBurst(t *testing.T)    { run(t, burstScenario) }
+
+// run runs a scenario on a new harness for the product mapping in
+// sync.json, with CONFLICT_POLICY=reject.
+func run(t *testing.T, scenario func(h *Harness) error) {
+	t.Setenv("SYNC_CONFIG", "../sync.json")
+	t.Setenv("CONFLICT_POLICY", database.ConflictReject)
+	if err := config.LoadTableMappings(); err != nil {
+		t.Fatal(err)
+	}
+	mapping, ok := config.MappingForTable("product")
+	if !ok {
+		t.Fatal("product table is not synced")
+	}
+
+	h, err := New(mapping, Seed()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if err := h.Check(); err != nil {
+		t.Fatalf("after the full sync: %v", err)
+	}
+	if err := scenario(h); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restScenario creates, updates, deletes and restores products through the
+// REST API.
+func restScenario(h *Harness) error {
+	w := h.REST(http.MethodPost, "/api/products", map[string]interface{}{"product_name": "Sprocket", "quantity": 7, "price": 4.25}, nil)
+	if err := expectStatus(w, http.StatusCreated, "create"); err != nil {
+		return err
+	}
+	id := strings.TrimPrefix(w.Body.String(), "Product created with UUID: ")
+	if err := h.Check(); err != nil {
+		return err
+	}
+
+	w = h.REST(http.MethodPut, "/api/products/"+id, map[string]interface{}{"price": 5}, ifMatch(1))
+	if err := expectStatus(w, http.StatusOK, "update"); err != nil {
+		return err
+	}
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		return fmt.Errorf("update: ETag %s, expected \"2\"", etag)
+	}
+	w = h.REST(http.MethodPut, "/api/products/"+id, map[string]interface{}{"price": 6}, ifMatch(1))
+	if err := expectStatus(w, http.StatusConflict, "stale update"); err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := expectCell(h, id, "Price", 5.0); err != nil {
+		return err
+	}
+	if err := expectChange(h, id, "price", "5", database.OriginAPI); err != nil {
+		return err
+	}
+
+	w = h.REST(http.MethodDelete, "/api/products/u-101", nil, nil)
+	if err := expectStatus(w, http.StatusOK, "delete"); err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	var trash []database.Product
+	if err := json.Unmarshal(h.REST(http.MethodGet, "/api/products/trash", nil, nil).Body.Bytes(), &trash); err != nil {
+		return err
+	}
+	if len(trash) != 1 || trash[0].UUID != "u-101" {
+		return fmt.Errorf("trash holds %v, expected u-101", trash)
+	}
+
+	w = h.REST(http.MethodPost, "/api/products/u-101/restore", nil, nil)
+	if err := expectStatus(w, http.StatusOK, "restore"); err != nil {
+		return err
+	}
+	return h.Check()
+}
+
+// webhookScenario edits the sheet: valid, invalid and atomic batches, a
+// redelivered batch, a new row and a deleted row.
+func webhookScenario(h *Harness) error {
+	w, _, err := h.Type(false, CellEdit{UUID: "u-101", Header: "Price", Value: 12.5})
+	if err != nil {
+		return err
+	}
+	if err := expectItems(w, handlers.ItemApplied); err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := expectCell(h, "u-101", "Version", 2.0); err != nil {
+		return err
+	}
+	if err := expectChange(h, "u-101", "price", "12.5", database.OriginSheet); err != nil {
+		return err
+	}
+
+	// Rejected, the cell keeps what was typed and is marked until fixed.
+	w, _, err = h.Type(false, CellEdit{UUID: "u-102", Header: "Quantity", Value: -3})
+	if err != nil {
+		return err
+	}
+	if err := expectItems(w, handlers.ItemInvalid); err != nil {
+		return err
+	}
+	if err := h.Sync(); err != nil {
+		return err
+	}
+	if note := h.Note("u-102", "Quantity"); !strings.HasPrefix(note, "Not saved") {
+		return fmt.Errorf("invalid edit: note %q on the cell", note)
+	}
+	if _, _, err := h.Type(false, CellEdit{UUID: "u-102", Header: "Quantity", Value: 4}); err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if note := h.Note("u-102", "Quantity"); note != "" {
+		return fmt.Errorf("fixed edit: note %q left on the cell", note)
+	}
+
+	// Notes users put on cells are not the sync's to clear.
+	h.SetNote("u-102", "Price", "Recheck with supplier")
+	if _, _, err := h.Type(false, CellEdit{UUID: "u-102", Header: "Price", Value: 26}); err != nil {
+		return err
+	}
+	if err := h.Sync(); err != nil {
+		return err
+	}
+	if note := h.Note("u-102", "Price"); note != "Recheck with supplier" {
+		return fmt.Errorf("valid edit: user's note replaced with %q", note)
+	}
+
+	// A batch the script sends again is applied once.
+	if _, _, err := h.Type(false, CellEdit{UUID: "u-103", Header: "Product Name", Value: "Gizmo XL"}); err != nil {
+		return err
+	}
+	w = h.Redeliver()
+	if w.Header().Get("X-Delivery-Replayed") != "true" {
+		return fmt.Errorf("redelivery was applied again")
+	}
+	if p, _ := h.Products.Get("u-103"); p.Version != 2 {
+		return fmt.Errorf("redelivery: u-103 has version %d, expected 2", p.Version)
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+
+	// An atomic batch with an invalid edit writes nothing. Typing the old
+	// values back puts the sheet right.
+	w, _, err = h.Type(true, CellEdit{UUID: "u-101", Header: "Quantity", Value: 3}, CellEdit{UUID: "u-101", Header: "Price", Value: "abc"})
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(w, http.StatusUnprocessableEntity, "atomic batch"); err != nil {
+		return err
+	}
+	if p, _ := h.Products.Get("u-101"); p.Quantity != 10 || p.Price != 12.5 {
+		return fmt.Errorf("atomic batch: u-101 changed to %d at %v", p.Quantity, p.Price)
+	}
+	if _, _, err := h.Type(false, CellEdit{UUID: "u-101", Header: "Quantity", Value: 10}, CellEdit{UUID: "u-101", Header: "Price", Value: 12.5}); err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+
+	w, created, err := h.Type(false, CellEdit{Header: "Product Name", Value: "Doohickey"})
+	if err != nil {
+		return err
+	}
+	if err := expectItems(w, handlers.ItemApplied); err != nil {
+		return fmt.Errorf("new row: %w", err)
+	}
+	if err := expectFailed(w, 0); err != nil {
+		return fmt.Errorf("new row: %w", err)
+	}
+	if p, err := h.Products.Get(created[0]); err != nil || p.ProductName != "Doohickey" {
+		return fmt.Errorf("new row: product %v, %v", p, err)
+	}
+
+	// Older scripts also send a new row's UUID and Updated By, which are
+	// taken as they are, even in an atomic batch. Neither those nor the
+	// blank Discount saved as false get a note.
+	h.OldScript = true
+	w, old, err := h.Type(true, CellEdit{Header: "Product Name", Value: "Whatsit"})
+	h.OldScript = false
+	if err != nil {
+		return err
+	}
+	if err := expectStatus(w, http.StatusOK, "new row from an older script"); err != nil {
+		return err
+	}
+	if err := expectFailed(w, 0); err != nil {
+		return fmt.Errorf("new row from an older script: %w", err)
+	}
+	if err := h.Sync(); err != nil {
+		return err
+	}
+	for _, id := range []string{created[0], old[0]} {
+		for _, header := range []string{"UUID", "Updated By", "Discount"} {
+			if note := h.Note(id, header); note != "" {
+				return fmt.Errorf("new row %s: note %q on %s", id, note, header)
+			}
+		}
+	}
+	// The script only fills in Price and Quantity, a blank Discount is saved
+	// as false but stays blank until typed.
+	if _, _, err := h.Type(false, CellEdit{UUID: created[0], Header: "Discount", Value: false}, CellEdit{UUID: old[0], Header: "Discount", Value: false}); err != nil {
+		return err
+	}
+	if err := h.Check(); err != nil {
+		return err
+	}
+
+	w, err = h.DeleteRows("u-102")
+	if err != nil {
+		return err
+	}
+	if err := expectItems(w, handlers.ItemApplied); err != nil {
+		return err
+	}
+	if _, err := h.Products.Get("u-102"); !errors.Is(err, database.ErrProductNotFound) {
+		return fmt.Errorf("row deleted in the sheet: product still there (%v)", err)
+	}
+	return h.Check()
+}
+
+// conflictScenario edits a row in the sheet that the API changed before the
+// change reached the sheet.
+func conflictScenario(h *Harness) error {
+	w := h.REST(http.MethodPut, "/api/products/u-101", map[string]interface{}{"price": 11}, ifMatch(1))
+	if err := expectStatus(w, http.StatusOK, "update"); err != nil {
+		return err
+	}
+
+	w, _, err := h.Type(false, CellEdit{UUID: "u-101", Header: "Price", Value: 8})
+	if err != nil {
+		return err
+	}
+	if err := expectItems(w, handlers.ItemConflict); err != nil {
+		return err
+	}
+	if len(h.Products.Conflicts()) != 1 {
+		return fmt.Errorf("%d conflicts logged, expected 1", len(h.Products.Conflicts()))
+	}
+
+	// The API's change overwrites the stale edit once synced.
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if err := expectCell(h, "u-101", "Price", 11.0); err != nil {
+		return err
+	}
+	if note := h.Note("u-101", "Price"); !strings.HasPrefix(note, "Not saved") {
+		return fmt.Errorf("conflict: note %q on the cell", note)
+	}
+	return nil
+}
+
+// restartScenario restarts the sync cleanly and after a crash that lost
+// changes the listener had already read.
+func restartScenario(h *Harness) error {
+	for _, name := range []string{"Alpha", "Beta"} {
+		w := h.REST(http.MethodPost, "/api/products", map[string]interface{}{"product_name": name, "price": 1}, nil)
+		if err := expectStatus(w, http.StatusCreated, "create"); err != nil {
+			return err
+		}
+	}
+	if err := h.Sync(); err != nil {
+		return err
+	}
+
+	h.REST(http.MethodPut, "/api/products/u-101", map[string]interface{}{"quantity": 42}, nil)
+	h.REST(http.MethodDelete, "/api/products/u-103", nil, nil)
+	if err := h.Restart(true); err != nil {
+		return err
+	}
+	recorded := len(h.History.Changes)
+
+	if err := h.Check(); err != nil {
+		return fmt.Errorf("after the crash: %w", err)
+	}
+	if len(h.History.Changes) != recorded {
+		return fmt.Errorf("history recorded %d changes twice", len(h.History.Changes)-recorded)
+	}
+
+	// The new SheetManager has to find rows again.
+	if _, _, err := h.Type(false, CellEdit{UUID: "u-102", Header: "Discount", Value: false}); err != nil {
+		return err
+	}
+	h.REST(http.MethodPut, "/api/products/u-102", map[string]interface{}{"product_name": "Gadget Pro"}, nil)
+	if err := h.Restart(false); err != nil {
+		return err
+	}
+	h.REST(http.MethodPost, "/api/products/u-103/restore", nil, nil)
+	return h.Check()
+}
+
+// burstScenario makes many changes at once, which reach the sheet in one
+// batch.
+func burstScenario(h *Harness) error {
+	for i := 0; i < 20; i++ {
+		w := h.REST(http.MethodPost, "/api/products", map[string]interface{}{"product_name": fmt.Sprintf("Bulk %d", i), "quantity": i}, nil)
+		if err := expectStatus(w, http.StatusCreated, "create"); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("u-10%d", 1+i%3)
+		h.REST(http.MethodPut, "/api/products/"+id, map[string]interface{}{"quantity": i}, nil)
+	}
+	h.REST(http.MethodDelete, "/api/products/u-102", nil, nil)
+
+	if err := h.Check(); err != nil {
+		return err
+	}
+	if rows := len(h.Sheets.Values(h.Mapping.Tab)) - 1; rows != 22 {
+		return fmt.Errorf("sheet has %d rows, expected 22", rows)
+	}
+	return nil
+}
+
+func ifMatch(version int64) http.Header {
+	return http.Header{"If-Match": {fmt.Sprintf(`"%d"`, version)}}
+}
+
+func expectStatus(w *httptest.ResponseRecorder, code int, what string) error {
+	if w.Code != code {
+		return fmt.Errorf("%s: status %d, expected %d: %s", what, w.Code, code, strings.TrimSpace(w.Body.String()))
+	}
+	return nil
+}
+
+// expectItems checks that every item of a webhook batch has status.
+func expectItems(w *httptest.ResponseRecorder, status string) error {
+	var resp handlers.SheetWebhookResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return fmt.Errorf("webhook: status %d: %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	for _, r := range resp.Results {
+		if r.Status != status {
+			return fmt.Errorf("webhook: %s %s %s, expected %s (%s)", r.UUID, r.Field, r.Status, status, r.Error)
+		}
+	}
+	return nil
+}
+
+// expectFailed checks how many items of a webhook batch were not saved.
+func expectFailed(w *httptest.ResponseRecorder, failed int) error {
+	var resp handlers.SheetWebhookResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return fmt.Errorf("webhook: status %d: %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	if resp.Failed != failed || resp.Processed != len(resp.Results)-failed {
+		return fmt.Errorf("webhook: %d processed and %d failed of %d, expected %d failed: %+v", resp.Processed, resp.Failed, len(resp.Results), failed, resp.Results)
+	}
+	return nil
+}
+
+func expectCell(h *Harness, id, header string, want interface{}) error {
+	if got := h.Cell(id, header); got != want {
+		return fmt.Errorf("%s %s shows %v, expected %v", id, header, got, want)
+	}
+	return nil
+}
+
+// expectChange checks the last change recorded for a field.
+func expectChange(h *Harness, id, field, value, origin string) error {
+	changes := h.History.Of(id)
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		if c.Field != field {
+			continue
+		}
+		if c.NewValue == nil || *c.NewValue != value || c.Origin != origin {
+			return fmt.Errorf("history of %s %s: last change to %v from %s, expected %s from %s", id, field, c.NewValue, c.Origin, value, origin)
+		}
+		return nil
+	}
+	return fmt.Errorf("history of %s has no change to %s", id, field)
+}
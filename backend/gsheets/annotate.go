@@ -0,0 +1,167 @@
+package gsheets
+
+import (
+	"log"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Kinds of cell annotation.
+const (
+	AnnotateError    = "error"    // the edit was not saved
+	AnnotateAdjusted = "adjusted" // saved, but not exactly as typed
+	AnnotateClear    = "clear"    // saved as typed, drop an earlier annotation
+)
+
+var annotationColors = map[string]*sheets.Color{
+	AnnotateError:    {Red: 0.96, Green: 0.8, Blue: 0.8},
+	AnnotateAdjusted: {Red: 1, Green: 0.95, Blue: 0.7},
+}
+
+// CellAnnotation marks a cell of a synced row with a background colour and a
+// note explaining what happened to the value typed into it.
+type CellAnnotation struct {
+	Table  string
+	RowID  string
+	Column string // database column name
+	Kind   string
+	Note   string
+}
+
+func (a CellAnnotation) cell() database.AnnotatedCell {
+	return database.AnnotatedCell{Table: a.Table, RowID: a.RowID, Column: a.Column}
+}
+
+// Feedback is what the webhook tells the sync worker about a batch of sheet
+// edits once it has been processed.
+type Feedback struct {
+	Annotations []CellAnnotation
+	// Removed lists the rows deleted in the sheet itself, by table.
+	Removed map[string][]string
+}
+
+// ApplyFeedback forgets the rows the sheet removed, then annotates cells.
+func (s *SheetManager) ApplyFeedback(f Feedback) error {
+	for table, ids := range f.Removed {
+		s.ForgetRows(table, ids)
+	}
+	return s.Annotate(f.Annotations)
+}
+
+// Annotate applies annotations in a single request. Cells whose row or
+// column can't be found are skipped, and so are clears of cells the sync
+// never annotated: those keep their notes and colours.
+func (s *SheetManager) Annotate(annotations []CellAnnotation) error {
+	var clears []database.AnnotatedCell
+	for _, a := range annotations {
+		if a.Kind == AnnotateClear {
+			clears = append(clears, a.cell())
+		}
+	}
+	annotated, err := s.Annotations.Annotated(clears)
+	if err != nil {
+		return err
+	}
+
+	// Rows are looked up once per tab, which checks their positions in one
+	// read.
+	ids := map[*Tab][]string{}
+	var tabs []*Tab
+	for _, a := range annotations {
+		if a.Kind == AnnotateClear && !annotated[a.cell()] {
+			continue
+		}
+		t, err := s.tab(a.Table)
+		if err != nil {
+			return err
+		}
+		if _, ok := ids[t]; !ok {
+			tabs = append(tabs, t)
+		}
+		ids[t] = append(ids[t], a.RowID)
+	}
+	rows := map[*Tab]map[string]int{}
+	for _, t := range tabs {
+		r, err := s.rowIndexes(t, ids[t])
+		if err != nil {
+			return err
+		}
+		rows[t] = r
+	}
+
+	var requests []*sheets.Request
+	var marked, cleared []database.AnnotatedCell
+	reloaded := map[string]bool{}
+
+	for _, a := range annotations {
+		if a.Kind == AnnotateClear && !annotated[a.cell()] {
+			continue
+		}
+
+		t, _ := s.tab(a.Table)
+
+		col := -1
+		for i, c := range t.Columns {
+			if c.Name == a.Column {
+				col = i
+			}
+		}
+		if col == -1 {
+			continue
+		}
+
+		row := rows[t][a.RowID]
+		// Rows typed into the sheet may not be indexed yet.
+		if row == -1 && !reloaded[t.Tab] {
+			reloaded[t.Tab] = true
+			if err := s.loadIndex(t); err != nil {
+				return err
+			}
+		}
+		if i, ok := t.index[a.RowID]; ok && row == -1 {
+			row = i
+		}
+		if row == -1 {
+			log.Printf("Cannot annotate %s %s, row not found in %s", a.Table, a.RowID, t.Tab)
+			continue
+		}
+
+		cell := &sheets.CellData{Note: a.Note, UserEnteredFormat: &sheets.CellFormat{}}
+		if color, ok := annotationColors[a.Kind]; ok {
+			cell.UserEnteredFormat.BackgroundColor = color
+			marked = append(marked, a.cell())
+		} else {
+			cleared = append(cleared, a.cell())
+		}
+
+		requests = append(requests, &sheets.Request{
+			UpdateCells: &sheets.UpdateCellsRequest{
+				Start: &sheets.GridCoordinate{
+					SheetId:     t.SheetID,
+					RowIndex:    int64(row),
+					ColumnIndex: int64(col),
+				},
+				Rows:   []*sheets.RowData{{Values: []*sheets.CellData{cell}}},
+				Fields: "note,userEnteredFormat.backgroundColor",
+			},
+		})
+	}
+
+	if len(requests) == 0 {
+		return nil
+	}
+
+	err = s.do("annotate cells", func() error {
+		_, err := s.Client.BatchUpdate(s.SpreadsheetID, requests)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.Annotations.SetAnnotated(marked, true); err != nil {
+		return err
+	}
+	return s.Annotations.SetAnnotated(cleared, false)
+}
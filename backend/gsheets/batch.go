@@ -0,0 +1,208 @@
+package gsheets
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
+	"google.golang.org/api/sheets/v4"
+)
+
+// ApplyBatch writes a set of coalesced row changes using one
+// Values.BatchUpdate for rows already in the sheet and one BatchUpdate for
+// every delete and append.
+func (s *SheetManager) ApplyBatch(events []cdc.SyncEvent) error {
+	type deletion struct {
+		tab   *Tab
+		id    string
+		index int
+	}
+
+	var updates []*sheets.ValueRange
+	var deletes []deletion
+	appends := map[*Tab][]*sheets.RowData{}
+	appendIDs := map[*Tab][]string{}
+	var appendOrder []*Tab
+	appended := 0
+
+	// Rows are looked up once per tab, which checks their positions in one
+	// read.
+	ids := map[*Tab][]string{}
+	var tabs []*Tab
+	for _, event := range events {
+		t, err := s.tab(event.Table)
+		if err != nil {
+			return err
+		}
+		if _, ok := ids[t]; !ok {
+			tabs = append(tabs, t)
+		}
+		ids[t] = append(ids[t], event.RowID)
+	}
+	rows := map[*Tab]map[string]int{}
+	for _, t := range tabs {
+		r, err := s.rowIndexes(t, ids[t])
+		if err != nil {
+			return err
+		}
+		rows[t] = r
+	}
+
+	reloaded := map[string]bool{}
+	for _, event := range events {
+		t, _ := s.tab(event.Table)
+		index := rows[t][event.RowID]
+		// Most likely a row just added in the sheet.
+		if index == -1 && event.Action == cdc.RefreshAction && !reloaded[t.Tab] {
+			reloaded[t.Tab] = true
+			if err := s.loadIndex(t); err != nil {
+				return err
+			}
+		}
+		if i, ok := t.index[event.RowID]; ok && index == -1 && event.Action == cdc.RefreshAction {
+			index = i
+		}
+
+		switch {
+		case event.Action == "delete":
+			if index != -1 {
+				deletes = append(deletes, deletion{tab: t, id: event.RowID, index: index})
+			}
+		case event.Action == cdc.RefreshAction:
+			if index != -1 {
+				updates = append(updates, t.readOnlyCells(index, event.Data)...)
+			}
+		case index != -1:
+			rowNum := index + 1
+			updates = append(updates, &sheets.ValueRange{
+				Range:  t.a1(fmt.Sprintf("A%d:%s%d", rowNum, t.lastColumn(), rowNum)),
+				Values: [][]interface{}{t.rowValues(event.Data)},
+			})
+		default:
+			if _, ok := appends[t]; !ok {
+				appendOrder = append(appendOrder, t)
+			}
+			appends[t] = append(appends[t], &sheets.RowData{Values: cellValues(t.rowValues(event.Data))})
+			appendIDs[t] = append(appendIDs[t], event.RowID)
+			appended++
+		}
+	}
+
+	if len(updates) > 0 {
+		err := s.do("batch update rows", func() error {
+			return s.Client.BatchUpdateValues(s.SpreadsheetID, updates)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update %d rows: %v", len(updates), err)
+		}
+	}
+
+	// Delete bottom-up so that earlier deletes don't shift later ones.
+	sort.Slice(deletes, func(i, j int) bool {
+		if deletes[i].tab != deletes[j].tab {
+			return deletes[i].tab.SheetID < deletes[j].tab.SheetID
+		}
+		return deletes[i].index > deletes[j].index
+	})
+
+	var requests []*sheets.Request
+	for _, d := range deletes {
+		requests = append(requests, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    d.tab.SheetID,
+					Dimension:  "ROWS",
+					StartIndex: int64(d.index),
+					EndIndex:   int64(d.index + 1),
+				},
+			},
+		})
+	}
+	for _, t := range appendOrder {
+		requests = append(requests, &sheets.Request{
+			AppendCells: &sheets.AppendCellsRequest{
+				SheetId: t.SheetID,
+				Rows:    appends[t],
+				Fields:  "userEnteredValue",
+			},
+		})
+	}
+
+	if len(requests) > 0 {
+		err := s.doOnce("batch delete/append rows", func() error {
+			_, err := s.Client.BatchUpdate(s.SpreadsheetID, requests)
+			return err
+		})
+		if err != nil {
+			// The requests may have been applied, don't trust the index.
+			for _, d := range deletes {
+				d.tab.index = nil
+			}
+			for _, t := range appendOrder {
+				t.index = nil
+			}
+			return fmt.Errorf("failed to apply %d deletes/appends: %v", len(requests), err)
+		}
+	}
+
+	for _, d := range deletes {
+		d.tab.removeFromIndex(d.id, d.index)
+	}
+	// AppendCells does not report where the rows landed, they are assumed to
+	// follow the indexed ones. The position check before the next write
+	// catches rows the index does not know about.
+	for _, t := range appendOrder {
+		if t.index == nil {
+			continue
+		}
+		last := len(t.index)
+		for i, id := range appendIDs[t] {
+			t.index[id] = last + 1 + i
+		}
+	}
+
+	log.Printf("Flushed batch: %d updated, %d deleted, %d appended", len(updates), len(deletes), appended)
+	return nil
+}
+
+// readOnlyCells returns one-cell ranges writing data's read-only columns,
+// other than the ID, into the row at index.
+func (t *Tab) readOnlyCells(index int, data map[string]interface{}) []*sheets.ValueRange {
+	var ranges []*sheets.ValueRange
+	for i, c := range t.Columns {
+		if !c.ReadOnly || c.Name == t.PrimaryKey {
+			continue
+		}
+		ranges = append(ranges, &sheets.ValueRange{
+			Range:  t.a1(fmt.Sprintf("%s%d", columnLetter(i), index+1)),
+			Values: [][]interface{}{{c.SheetValue(data[c.Name])}},
+		})
+	}
+	return ranges
+}
+
+// cellValues converts row values to cells for AppendCells, which unlike the
+// values API needs the value type spelled out.
+func cellValues(values []interface{}) []*sheets.CellData {
+	cells := make([]*sheets.CellData, len(values))
+	for i, v := range values {
+		ev := &sheets.ExtendedValue{}
+		switch val := v.(type) {
+		case bool:
+			ev.BoolValue = &val
+		case int:
+			f := float64(val)
+			ev.NumberValue = &f
+		case int64:
+			f := float64(val)
+			ev.NumberValue = &f
+		case float64:
+			ev.NumberValue = &val
+		default:
+			ev.StringValue = strPtr(fmt.Sprintf("%v", val))
+		}
+		cells[i] = &sheets.CellData{UserEnteredValue: ev}
+	}
+	return cells
+}
@@ -0,0 +1,65 @@
+package gsheets
+
+import (
+	"google.golang.org/api/sheets/v4"
+)
+
+// Client is the part of the Sheets API the SheetManager uses. Values are
+// always written RAW.
+type Client interface {
+	// GetSpreadsheet returns the spreadsheet with the properties of its tabs.
+	GetSpreadsheet(spreadsheetID string) (*sheets.Spreadsheet, error)
+	// GetValues reads a range. An empty renderOption means FORMATTED_VALUE.
+	GetValues(spreadsheetID, a1, renderOption string) (*sheets.ValueRange, error)
+	UpdateValues(spreadsheetID, a1 string, values *sheets.ValueRange) error
+	BatchUpdateValues(spreadsheetID string, data []*sheets.ValueRange) error
+	AppendValues(spreadsheetID, a1 string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error)
+	ClearValues(spreadsheetID, a1 string) error
+	BatchUpdate(spreadsheetID string, requests []*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error)
+}
+
+// NewClient returns a Client making its calls through srv.
+func NewClient(srv *sheets.Service) Client {
+	return serviceClient{srv}
+}
+
+type serviceClient struct {
+	srv *sheets.Service
+}
+
+func (c serviceClient) GetSpreadsheet(spreadsheetID string) (*sheets.Spreadsheet, error) {
+	return c.srv.Spreadsheets.Get(spreadsheetID).Fields("spreadsheetId,sheets.properties(sheetId,title,index)").Do()
+}
+
+func (c serviceClient) GetValues(spreadsheetID, a1, renderOption string) (*sheets.ValueRange, error) {
+	call := c.srv.Spreadsheets.Values.Get(spreadsheetID, a1)
+	if renderOption != "" {
+		call = call.ValueRenderOption(renderOption)
+	}
+	return call.Do()
+}
+
+func (c serviceClient) UpdateValues(spreadsheetID, a1 string, values *sheets.ValueRange) error {
+	_, err := c.srv.Spreadsheets.Values.Update(spreadsheetID, a1, values).ValueInputOption("RAW").Do()
+	return err
+}
+
+func (c serviceClient) BatchUpdateValues(spreadsheetID string, data []*sheets.ValueRange) error {
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: data}
+	_, err := c.srv.Spreadsheets.Values.BatchUpdate(spreadsheetID, req).Do()
+	return err
+}
+
+func (c serviceClient) AppendValues(spreadsheetID, a1 string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	return c.srv.Spreadsheets.Values.Append(spreadsheetID, a1, values).ValueInputOption("RAW").Do()
+}
+
+func (c serviceClient) ClearValues(spreadsheetID, a1 string) error {
+	_, err := c.srv.Spreadsheets.Values.Clear(spreadsheetID, a1, &sheets.ClearValuesRequest{}).Do()
+	return err
+}
+
+func (c serviceClient) BatchUpdate(spreadsheetID string, requests []*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	req := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	return c.srv.Spreadsheets.BatchUpdate(spreadsheetID, req).Do()
+}
@@ -0,0 +1,109 @@
+package gsheets
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// RowDrift describes a row whose sheet and database versions differ.
+type RowDrift struct {
+	RowID string
+	Kind  string
+	// Fields lists the differing columns of a mismatched row.
+	Fields []string
+	// DB and Sheet hold the row on each side keyed by column name, nil when
+	// the row is missing there. Sheet values are the raw cell values.
+	DB    map[string]interface{}
+	Sheet map[string]interface{}
+}
+
+// Diff compares every row of a table with what its tab currently shows.
+// rows must be the full contents of the table.
+func (s *SheetManager) Diff(table string, rows []map[string]interface{}) ([]RowDrift, error) {
+	t, err := s.tab(table)
+	if err != nil {
+		return nil, err
+	}
+
+	current, _, err := s.ReadRows(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []RowDrift
+	seen := map[string]bool{}
+
+	for _, row := range rows {
+		id := fmt.Sprintf("%v", row[t.PrimaryKey])
+		seen[id] = true
+
+		sheetRow, ok := current[id]
+		if !ok {
+			drifts = append(drifts, RowDrift{RowID: id, Kind: database.DriftMissingInSheet, DB: row})
+			continue
+		}
+
+		var fields []string
+		want := t.rowValues(row)
+		for i, c := range t.Columns {
+			if cellString(cellAt(sheetRow.Values, i)) != cellString(want[i]) {
+				fields = append(fields, c.Name)
+			}
+		}
+		if len(fields) > 0 {
+			drifts = append(drifts, RowDrift{RowID: id, Kind: database.DriftMismatch, Fields: fields, DB: row, Sheet: t.sheetRowData(sheetRow)})
+		}
+	}
+
+	var extra []string
+	for id := range current {
+		if !seen[id] {
+			extra = append(extra, id)
+		}
+	}
+	sort.Strings(extra)
+	for _, id := range extra {
+		drifts = append(drifts, RowDrift{RowID: id, Kind: database.DriftMissingInDB, Sheet: t.sheetRowData(current[id])})
+	}
+
+	return drifts, nil
+}
+
+// Discrepancies flattens a drift into the records kept in sync_drift, one per
+// differing field or a single one for a missing row.
+func (d RowDrift) Discrepancies(table string) []database.Discrepancy {
+	if d.Kind != database.DriftMismatch {
+		return []database.Discrepancy{{Table: table, RowID: d.RowID, Kind: d.Kind}}
+	}
+
+	var result []database.Discrepancy
+	for _, field := range d.Fields {
+		result = append(result, database.Discrepancy{
+			Table:      table,
+			RowID:      d.RowID,
+			Field:      field,
+			Kind:       d.Kind,
+			DBValue:    cellString(d.DB[field]),
+			SheetValue: cellString(d.Sheet[field]),
+		})
+	}
+	return result
+}
+
+// sheetRowData keys the cells of a sheet row by column name.
+func (t *Tab) sheetRowData(row SheetRow) map[string]interface{} {
+	data := make(map[string]interface{}, len(t.Columns))
+	for i, c := range t.Columns {
+		data[c.Name] = cellAt(row.Values, i)
+	}
+	return data
+}
+
+func cellAt(cells []interface{}, i int) interface{} {
+	if i < len(cells) {
+		return cells[i]
+	}
+	return nil
+}
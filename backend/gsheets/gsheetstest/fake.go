@@ -0,0 +1,747 @@
+// Package gsheetstest provides a fake Google Sheets server for tests of the
+// sync.
+package gsheetstest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// FakeSheets is a local HTTP server implementing the Sheets v4 endpoints the
+// SheetManager uses, against a spreadsheet kept in memory, so the sync can
+// run without a Google account. Only cell values and notes are kept,
+// formatting requests are accepted and ignored. Numbers are read back
+// without their number format.
+type FakeSheets struct {
+	SpreadsheetID string
+
+	mu            sync.Mutex
+	tabs          []*fakeTab
+	nextID        int64
+	failures      []int
+	writeFailures []int
+	server        *httptest.Server
+}
+
+type fakeTab struct {
+	id    int64
+	title string
+	cells [][]fakeCell
+}
+
+type fakeCell struct {
+	value interface{} // nil if empty
+	note  string
+}
+
+// NewFakeSheets starts a server for a spreadsheet with the given tabs, or a
+// single "Sheet1" like a new spreadsheet. Close it when done.
+func NewFakeSheets(tabs ...string) *FakeSheets {
+	if len(tabs) == 0 {
+		tabs = []string{"Sheet1"}
+	}
+	f := &FakeSheets{SpreadsheetID: "fake-spreadsheet"}
+	for _, title := range tabs {
+		f.addTab(title, 0)
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *FakeSheets) URL() string {
+	return f.server.URL
+}
+
+func (f *FakeSheets) Close() {
+	f.server.Close()
+}
+
+// Client returns a Client talking to the server.
+func (f *FakeSheets) Client() (gsheets.Client, error) {
+	srv, err := sheets.NewService(context.Background(), option.WithEndpoint(f.server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		return nil, err
+	}
+	return gsheets.NewClient(srv), nil
+}
+
+// SheetManager returns a SheetManager for the fake spreadsheet.
+func (f *FakeSheets) SheetManager(mappings []config.TableMapping) (*gsheets.SheetManager, error) {
+	client, err := f.Client()
+	if err != nil {
+		return nil, err
+	}
+	return gsheets.NewSheetManagerWithClient(client, f.SpreadsheetID, mappings)
+}
+
+// Fail makes the next calls fail with the given HTTP status codes, one call
+// per code.
+func (f *FakeSheets) Fail(codes ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, codes...)
+}
+
+// FailWrites is like Fail but only fails calls that change the spreadsheet,
+// letting reads through.
+func (f *FakeSheets) FailWrites(codes ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeFailures = append(f.writeFailures, codes...)
+}
+
+// Values returns the values of a tab, header included, as stored: strings,
+// float64s and bools, "" for empty cells. Trailing empty rows and cells are
+// left out like the API does. It returns nil for a missing tab.
+func (f *FakeSheets) Values(tab string) [][]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := f.tabByTitle(tab)
+	if t == nil {
+		return nil
+	}
+	return t.values(fakeRange{tab: t, row1: -1, col1: -1}, "UNFORMATTED_VALUE")
+}
+
+// SetValues replaces the contents of a tab, creating it if needed. It is
+// how a test types into the sheet.
+func (f *FakeSheets) SetValues(tab string, rows [][]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := f.tabByTitle(tab)
+	if t == nil {
+		t = f.addTab(tab, 0)
+	}
+	t.cells = nil
+	t.write(0, 0, rows)
+}
+
+// SetCell types a value into a cell, by zero-based row and column. nil or
+// "" empties it.
+func (f *FakeSheets) SetCell(tab string, row, col int, value interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := f.tabByTitle(tab)
+	if t == nil {
+		t = f.addTab(tab, 0)
+	}
+	if value == nil {
+		value = ""
+	}
+	t.write(row, col, [][]interface{}{{value}})
+}
+
+// RemoveRow deletes a row the way a user does, moving the rows below up.
+func (f *FakeSheets) RemoveRow(tab string, row int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t := f.tabByTitle(tab); t != nil {
+		t.cells = cut(t.cells, row, row+1)
+	}
+}
+
+// Note returns the note on a cell, by zero-based row and column.
+func (f *FakeSheets) Note(tab string, row, col int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := f.tabByTitle(tab)
+	if t == nil || row >= len(t.cells) || col >= len(t.cells[row]) {
+		return ""
+	}
+	return t.cells[row][col].note
+}
+
+// SetNote puts a note on a cell the way a user does.
+func (f *FakeSheets) SetNote(tab string, row, col int, note string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t := f.tabByTitle(tab); t != nil {
+		t.cell(row, col).note = note
+	}
+}
+
+func (f *FakeSheets) addTab(title string, id int64) *fakeTab {
+	if id == 0 {
+		id = f.nextID
+	}
+	if id >= f.nextID {
+		f.nextID = id + 1
+	}
+	t := &fakeTab{id: id, title: title}
+	f.tabs = append(f.tabs, t)
+	return t
+}
+
+func (f *FakeSheets) tabByTitle(title string) *fakeTab {
+	for _, t := range f.tabs {
+		if t.title == title {
+			return t
+		}
+	}
+	return nil
+}
+
+func (f *FakeSheets) tabByID(id int64) *fakeTab {
+	for _, t := range f.tabs {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// fakeError is an error response in the format the API client decodes.
+type fakeError struct {
+	code int
+	msg  string
+}
+
+func (e *fakeError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &fakeError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func (f *FakeSheets) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var resp interface{}
+	var err error
+	if len(f.failures) > 0 {
+		err = &fakeError{f.failures[0], "injected failure"}
+		f.failures = f.failures[1:]
+	} else if len(f.writeFailures) > 0 && r.Method != http.MethodGet {
+		err = &fakeError{f.writeFailures[0], "injected failure"}
+		f.writeFailures = f.writeFailures[1:]
+	} else {
+		resp, err = f.route(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		e, ok := err.(*fakeError)
+		if !ok {
+			e = &fakeError{http.StatusInternalServerError, err.Error()}
+		}
+		w.WriteHeader(e.code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{"code": e.code, "message": e.msg, "status": http.StatusText(e.code)},
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// route dispatches on the paths of the Sheets v4 REST API:
+//
+//	GET  /v4/spreadsheets/{id}
+//	POST /v4/spreadsheets/{id}:batchUpdate
+//	POST /v4/spreadsheets/{id}/values:batchUpdate
+//	GET  /v4/spreadsheets/{id}/values/{range}
+//	PUT  /v4/spreadsheets/{id}/values/{range}
+//	POST /v4/spreadsheets/{id}/values/{range}:append
+//	POST /v4/spreadsheets/{id}/values/{range}:clear
+func (f *FakeSheets) route(r *http.Request) (interface{}, error) {
+	path, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v4/spreadsheets/")
+	if !ok {
+		return nil, &fakeError{http.StatusNotFound, "unknown endpoint " + r.URL.Path}
+	}
+
+	id, rest, _ := strings.Cut(path, "/")
+	id, method, _ := strings.Cut(id, ":")
+	if id != f.SpreadsheetID {
+		return nil, &fakeError{http.StatusNotFound, "Requested entity was not found."}
+	}
+
+	switch {
+	case rest == "" && method == "" && r.Method == http.MethodGet:
+		return f.spreadsheet(), nil
+	case rest == "" && method == "batchUpdate" && r.Method == http.MethodPost:
+		var req sheets.BatchUpdateSpreadsheetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, badRequest("invalid body: %v", err)
+		}
+		return f.batchUpdate(req.Requests)
+	case rest == "values:batchUpdate" && r.Method == http.MethodPost:
+		var req sheets.BatchUpdateValuesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, badRequest("invalid body: %v", err)
+		}
+		return f.batchUpdateValues(req.Data)
+	}
+
+	escaped, ok := strings.CutPrefix(rest, "values/")
+	if !ok {
+		return nil, &fakeError{http.StatusNotFound, "unknown endpoint " + r.URL.Path}
+	}
+	var action string
+	for _, a := range []string{":append", ":clear"} {
+		if strings.HasSuffix(escaped, a) {
+			escaped, action = strings.TrimSuffix(escaped, a), a
+		}
+	}
+	a1, err := url.PathUnescape(escaped)
+	if err != nil {
+		return nil, badRequest("invalid range %q", escaped)
+	}
+	rng, err := f.parseRange(a1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		render := r.URL.Query().Get("valueRenderOption")
+		return &sheets.ValueRange{Range: rng.String(), MajorDimension: "ROWS", Values: rng.tab.values(rng, render)}, nil
+	case action == "" && r.Method == http.MethodPut:
+		var vr sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+			return nil, badRequest("invalid body: %v", err)
+		}
+		return rng.tab.update(rng, vr.Values), nil
+	case action == ":append" && r.Method == http.MethodPost:
+		var vr sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+			return nil, badRequest("invalid body: %v", err)
+		}
+		rng.row0 = rng.tab.dataRows()
+		return &sheets.AppendValuesResponse{SpreadsheetId: f.SpreadsheetID, Updates: rng.tab.update(rng, vr.Values)}, nil
+	case action == ":clear" && r.Method == http.MethodPost:
+		rng.tab.clear(rng)
+		return &sheets.ClearValuesResponse{SpreadsheetId: f.SpreadsheetID, ClearedRange: rng.String()}, nil
+	}
+	return nil, &fakeError{http.StatusNotFound, "unknown endpoint " + r.URL.Path}
+}
+
+func (f *FakeSheets) spreadsheet() *sheets.Spreadsheet {
+	ss := &sheets.Spreadsheet{SpreadsheetId: f.SpreadsheetID}
+	for i, t := range f.tabs {
+		ss.Sheets = append(ss.Sheets, &sheets.Sheet{
+			Properties: &sheets.SheetProperties{SheetId: t.id, Title: t.title, Index: int64(i)},
+		})
+	}
+	return ss
+}
+
+func (f *FakeSheets) batchUpdateValues(data []*sheets.ValueRange) (interface{}, error) {
+	var ranges []fakeRange
+	for _, vr := range data {
+		rng, err := f.parseRange(vr.Range)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, rng)
+	}
+
+	resp := &sheets.BatchUpdateValuesResponse{SpreadsheetId: f.SpreadsheetID}
+	for i, rng := range ranges {
+		resp.Responses = append(resp.Responses, rng.tab.update(rng, data[i].Values))
+	}
+	return resp, nil
+}
+
+// batchUpdate applies the requests in order, all or none of them.
+func (f *FakeSheets) batchUpdate(requests []*sheets.Request) (interface{}, error) {
+	saved := make([]*fakeTab, len(f.tabs))
+	for i, t := range f.tabs {
+		saved[i] = t.copy()
+	}
+	savedID := f.nextID
+
+	resp := &sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: f.SpreadsheetID}
+	for i, req := range requests {
+		reply, err := f.apply(req)
+		if err != nil {
+			f.tabs, f.nextID = saved, savedID
+			return nil, badRequest("Invalid requests[%d]: %v", i, err)
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	return resp, nil
+}
+
+func (f *FakeSheets) apply(req *sheets.Request) (*sheets.Response, error) {
+	switch {
+	case req.AddSheet != nil:
+		p := req.AddSheet.Properties
+		if p == nil || p.Title == "" {
+			return nil, fmt.Errorf("addSheet needs a title")
+		}
+		if f.tabByTitle(p.Title) != nil {
+			return nil, fmt.Errorf("a sheet with the name %q already exists", p.Title)
+		}
+		if p.SheetId != 0 && f.tabByID(p.SheetId) != nil {
+			return nil, fmt.Errorf("a sheet with id %d already exists", p.SheetId)
+		}
+		t := f.addTab(p.Title, p.SheetId)
+		return &sheets.Response{AddSheet: &sheets.AddSheetResponse{
+			Properties: &sheets.SheetProperties{SheetId: t.id, Title: t.title, Index: int64(len(f.tabs) - 1)},
+		}}, nil
+
+	case req.DeleteDimension != nil:
+		d := req.DeleteDimension.Range
+		t := f.tabByID(d.SheetId)
+		if t == nil {
+			return nil, fmt.Errorf("no sheet with id %d", d.SheetId)
+		}
+		if d.StartIndex < 0 || d.EndIndex <= d.StartIndex {
+			return nil, fmt.Errorf("invalid range %d-%d", d.StartIndex, d.EndIndex)
+		}
+		switch d.Dimension {
+		case "ROWS":
+			t.cells = cut(t.cells, int(d.StartIndex), int(d.EndIndex))
+		case "COLUMNS":
+			for i := range t.cells {
+				t.cells[i] = cut(t.cells[i], int(d.StartIndex), int(d.EndIndex))
+			}
+		default:
+			return nil, fmt.Errorf("unknown dimension %q", d.Dimension)
+		}
+
+	case req.AppendCells != nil:
+		t := f.tabByID(req.AppendCells.SheetId)
+		if t == nil {
+			return nil, fmt.Errorf("no sheet with id %d", req.AppendCells.SheetId)
+		}
+		t.setCells(t.dataRows(), 0, req.AppendCells.Rows, req.AppendCells.Fields)
+
+	case req.UpdateCells != nil:
+		u := req.UpdateCells
+		var sheetID, row, col int64
+		switch {
+		case u.Start != nil:
+			sheetID, row, col = u.Start.SheetId, u.Start.RowIndex, u.Start.ColumnIndex
+		case u.Range != nil:
+			sheetID, row, col = u.Range.SheetId, u.Range.StartRowIndex, u.Range.StartColumnIndex
+		}
+		t := f.tabByID(sheetID)
+		if t == nil {
+			return nil, fmt.Errorf("no sheet with id %d", sheetID)
+		}
+		t.setCells(int(row), int(col), u.Rows, u.Fields)
+
+	case req.RepeatCell != nil, req.UpdateSheetProperties != nil,
+		req.UpdateDimensionProperties != nil, req.AutoResizeDimensions != nil:
+		// Formatting only.
+
+	default:
+		return nil, fmt.Errorf("request not supported by the fake")
+	}
+	return &sheets.Response{}, nil
+}
+
+// cut removes s[start:end], clipped to the length of s.
+func cut[T any](s []T, start, end int) []T {
+	if start >= len(s) {
+		return s
+	}
+	if end > len(s) {
+		end = len(s)
+	}
+	return append(s[:start], s[end:]...)
+}
+
+func (t *fakeTab) copy() *fakeTab {
+	c := &fakeTab{id: t.id, title: t.title, cells: make([][]fakeCell, len(t.cells))}
+	for i, row := range t.cells {
+		c.cells[i] = append([]fakeCell{}, row...)
+	}
+	return c
+}
+
+// cell returns a cell, growing the grid to reach it.
+func (t *fakeTab) cell(row, col int) *fakeCell {
+	for len(t.cells) <= row {
+		t.cells = append(t.cells, nil)
+	}
+	for len(t.cells[row]) <= col {
+		t.cells[row] = append(t.cells[row], fakeCell{})
+	}
+	return &t.cells[row][col]
+}
+
+// dataRows is the number of rows up to the last one with a value, which is
+// where appends go.
+func (t *fakeTab) dataRows() int {
+	for i := len(t.cells) - 1; i >= 0; i-- {
+		for _, c := range t.cells[i] {
+			if c.value != nil {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+func (t *fakeTab) values(rng fakeRange, render string) [][]interface{} {
+	var rows [][]interface{}
+	for i := rng.row0; i < len(t.cells) && (rng.row1 < 0 || i < rng.row1); i++ {
+		var row []interface{}
+		for j := rng.col0; j < len(t.cells[i]) && (rng.col1 < 0 || j < rng.col1); j++ {
+			row = append(row, t.cells[i][j].value)
+		}
+		for len(row) > 0 && row[len(row)-1] == nil {
+			row = row[:len(row)-1]
+		}
+		for j, v := range row {
+			row[j] = renderValue(v, render)
+		}
+		rows = append(rows, row)
+	}
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	for i := range rows {
+		if rows[i] == nil {
+			rows[i] = []interface{}{}
+		}
+	}
+	return rows
+}
+
+// renderValue returns a value the way the API reads it back.
+func renderValue(v interface{}, render string) interface{} {
+	if v == nil {
+		return ""
+	}
+	if render == "UNFORMATTED_VALUE" {
+		return v
+	}
+	switch val := v.(type) {
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(val))
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// write stores values RAW from a cell on. Null values leave their cell as
+// it is, empty strings clear it.
+func (t *fakeTab) write(row, col int, values [][]interface{}) (rows, cols int) {
+	for i, r := range values {
+		for j, v := range r {
+			if v == nil {
+				continue
+			}
+			if s, ok := v.(string); ok && s == "" {
+				v = nil
+			}
+			t.cell(row+i, col+j).value = v
+			if j+1 > cols {
+				cols = j + 1
+			}
+		}
+	}
+	return len(values), cols
+}
+
+func (t *fakeTab) update(rng fakeRange, values [][]interface{}) *sheets.UpdateValuesResponse {
+	rows, cols := t.write(rng.row0, rng.col0, values)
+	resp := &sheets.UpdateValuesResponse{UpdatedRows: int64(rows), UpdatedColumns: int64(cols), UpdatedCells: int64(rows * cols)}
+	if rows > 0 && cols > 0 {
+		written := fakeRange{tab: t, row0: rng.row0, col0: rng.col0, row1: rng.row0 + rows, col1: rng.col0 + cols}
+		resp.UpdatedRange = written.String()
+	}
+	return resp
+}
+
+// clear empties the values in a range, leaving notes.
+func (t *fakeTab) clear(rng fakeRange) {
+	for i := rng.row0; i < len(t.cells) && (rng.row1 < 0 || i < rng.row1); i++ {
+		for j := rng.col0; j < len(t.cells[i]) && (rng.col1 < 0 || j < rng.col1); j++ {
+			t.cells[i][j].value = nil
+		}
+	}
+}
+
+// setCells applies CellData from a cell on. Only values and notes named in
+// fields ("*" for all) are set.
+func (t *fakeTab) setCells(row, col int, data []*sheets.RowData, fields string) {
+	all := fields == "*"
+	setValue := all || strings.Contains(fields, "userEnteredValue")
+	setNote := all || strings.Contains(fields, "note")
+
+	for i, r := range data {
+		if r == nil {
+			continue
+		}
+		for j, cd := range r.Values {
+			c := t.cell(row+i, col+j)
+			if setValue {
+				c.value = nil
+				if cd != nil {
+					c.value = extendedValue(cd.UserEnteredValue)
+				}
+			}
+			if setNote {
+				c.note = ""
+				if cd != nil {
+					c.note = cd.Note
+				}
+			}
+		}
+	}
+}
+
+func extendedValue(ev *sheets.ExtendedValue) interface{} {
+	switch {
+	case ev == nil:
+		return nil
+	case ev.NumberValue != nil:
+		return *ev.NumberValue
+	case ev.BoolValue != nil:
+		return *ev.BoolValue
+	case ev.StringValue != nil:
+		if *ev.StringValue == "" {
+			return nil
+		}
+		return *ev.StringValue
+	case ev.FormulaValue != nil:
+		return *ev.FormulaValue
+	}
+	return nil
+}
+
+// fakeRange is a parsed A1 range. row1 and col1 are exclusive, -1 if the
+// range is open ended.
+type fakeRange struct {
+	tab        *fakeTab
+	row0, col0 int
+	row1, col1 int
+}
+
+func (r fakeRange) String() string {
+	start := columnLetter(r.col0) + strconv.Itoa(r.row0+1)
+	end := ""
+	if r.col1 > 0 {
+		end = columnLetter(r.col1 - 1)
+	}
+	if r.row1 > 0 {
+		end += strconv.Itoa(r.row1)
+	}
+	if end != "" {
+		start += ":" + end
+	}
+	return fmt.Sprintf("'%s'!%s", strings.ReplaceAll(r.tab.title, "'", "''"), start)
+}
+
+// columnLetter converts a zero-based column index to its A1 letters.
+func columnLetter(index int) string {
+	letters := ""
+	for index >= 0 {
+		letters = string(rune('A'+index%26)) + letters
+		index = index/26 - 1
+	}
+	return letters
+}
+
+// parseRange reads ranges like "'My Tab'!A2:G", "Sheet1!A:A" or "Sheet1".
+func (f *FakeSheets) parseRange(a1 string) (fakeRange, error) {
+	var title, cells string
+	if strings.HasPrefix(a1, "'") {
+		end := 1
+		for {
+			i := strings.Index(a1[end:], "'")
+			if i == -1 {
+				return fakeRange{}, badRequest("Unable to parse range: %s", a1)
+			}
+			end += i
+			if !strings.HasPrefix(a1[end:], "''") {
+				break
+			}
+			end += 2
+		}
+		title = strings.ReplaceAll(a1[1:end], "''", "'")
+		cells = strings.TrimPrefix(a1[end+1:], "!")
+	} else {
+		title, cells, _ = strings.Cut(a1, "!")
+	}
+
+	t := f.tabByTitle(title)
+	if t == nil {
+		return fakeRange{}, badRequest("Unable to parse range: %s", a1)
+	}
+	rng := fakeRange{tab: t, row1: -1, col1: -1}
+	if cells == "" {
+		return rng, nil
+	}
+
+	from, to, isRange := strings.Cut(cells, ":")
+	col, row, ok := parseCell(from)
+	if !ok {
+		return fakeRange{}, badRequest("Unable to parse range: %s", a1)
+	}
+	if col >= 0 {
+		rng.col0 = col
+	}
+	if row >= 0 {
+		rng.row0 = row
+	}
+
+	if !isRange {
+		// A single cell.
+		rng.row1, rng.col1 = rng.row0+1, rng.col0+1
+		return rng, nil
+	}
+	col, row, ok = parseCell(to)
+	if !ok {
+		return fakeRange{}, badRequest("Unable to parse range: %s", a1)
+	}
+	if col >= 0 {
+		rng.col1 = col + 1
+	}
+	if row >= 0 {
+		rng.row1 = row + 1
+	}
+	return rng, nil
+}
+
+// parseCell reads "B3", "B" or "3" as zero-based column and row, -1 for a
+// part that is left out.
+func parseCell(s string) (col, row int, ok bool) {
+	letters := strings.TrimRight(s, "0123456789")
+	digits := s[len(letters):]
+	if s == "" || strings.Trim(letters, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return 0, 0, false
+	}
+
+	col = -1
+	if letters != "" {
+		col = 0
+		for _, c := range letters {
+			col = col*26 + int(c-'A') + 1
+		}
+		col--
+	}
+	row = -1
+	if digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		row = n - 1
+	}
+	return col, row, true
+}
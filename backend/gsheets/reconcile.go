@@ -0,0 +1,171 @@
+package gsheets
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
+	"google.golang.org/api/sheets/v4"
+)
+
+// ReconcileReport lists the row IDs Reconcile had to touch in a tab.
+type ReconcileReport struct {
+	Tab       string   `json:"tab"`
+	Updated   []string `json:"updated"`
+	Appended  []string `json:"appended"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+}
+
+func (r *ReconcileReport) String() string {
+	return fmt.Sprintf("%s: %d updated, %d appended, %d deleted, %d unchanged",
+		r.Tab, len(r.Updated), len(r.Appended), len(r.Deleted), r.Unchanged)
+}
+
+// SheetRow is a data row as currently shown in a tab.
+type SheetRow struct {
+	Index  int // zero-based row index in the tab
+	Values []interface{}
+}
+
+// ReadRows reads every data row of a tab that has an ID, keyed by that ID.
+// It also refreshes the tab's row index. Rows whose ID appears more than
+// once are returned in duplicates.
+func (s *SheetManager) ReadRows(table string) (rows map[string]SheetRow, duplicates []SheetRow, err error) {
+	t, err := s.tab(table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resp *sheets.ValueRange
+	err = s.do("read tab", func() (err error) {
+		resp, err = s.Client.GetValues(s.SpreadsheetID, t.a1("A2:"+t.lastColumn()), "UNFORMATTED_VALUE")
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %v", t.Tab, err)
+	}
+
+	rows = map[string]SheetRow{}
+	index := map[string]int{}
+	for i, values := range resp.Values {
+		if len(values) == 0 || cellString(values[0]) == "" {
+			continue
+		}
+		row := SheetRow{Index: i + 1, Values: values}
+		id := cellString(values[0])
+
+		if _, dup := rows[id]; dup {
+			duplicates = append(duplicates, row)
+			continue
+		}
+		rows[id] = row
+		index[id] = row.Index
+	}
+
+	t.index = index
+	t.indexedAt = time.Now()
+	return rows, duplicates, nil
+}
+
+// Reconcile makes a tab match rows (the full contents of its table) by
+// writing only the rows that differ, appending missing ones and deleting
+// rows that no longer exist in the database. Columns to the right of the
+// mapped ones and formatting are left alone.
+func (s *SheetManager) Reconcile(table string, rows []map[string]interface{}) (*ReconcileReport, error) {
+	t, err := s.tab(table)
+	if err != nil {
+		return nil, err
+	}
+
+	current, duplicates, err := s.ReadRows(table)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Tab: t.Tab}
+
+	// Rows repeating an ID (e.g. copy-pasted) are removed first; the
+	// earliest occurrence is kept and compared below.
+	if len(duplicates) > 0 {
+		var indexes []int
+		for i := len(duplicates) - 1; i >= 0; i-- {
+			indexes = append(indexes, duplicates[i].Index)
+			report.Deleted = append(report.Deleted, cellString(duplicates[i].Values[0]))
+		}
+		if err := s.deleteRowsAt(t, indexes); err != nil {
+			return nil, err
+		}
+		if current, _, err = s.ReadRows(table); err != nil {
+			return nil, err
+		}
+	}
+
+	var events []cdc.SyncEvent
+	seen := map[string]bool{}
+
+	for _, row := range rows {
+		id := fmt.Sprintf("%v", row[t.PrimaryKey])
+		seen[id] = true
+
+		sheetRow, ok := current[id]
+		if !ok {
+			report.Appended = append(report.Appended, id)
+		} else if !t.rowMatches(sheetRow.Values, row) {
+			report.Updated = append(report.Updated, id)
+		} else {
+			report.Unchanged++
+			continue
+		}
+		events = append(events, cdc.SyncEvent{Source: "RECONCILE", Table: table, RowID: id, Action: "update", Data: row})
+	}
+
+	for id := range current {
+		if !seen[id] {
+			report.Deleted = append(report.Deleted, id)
+			events = append(events, cdc.SyncEvent{Source: "RECONCILE", Table: table, RowID: id, Action: "delete"})
+		}
+	}
+
+	if err := s.ApplyBatch(events); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reconciled %s", report)
+	return report, nil
+}
+
+// rowMatches reports whether the cells of a sheet row already show data.
+func (t *Tab) rowMatches(cells []interface{}, data map[string]interface{}) bool {
+	for i, want := range t.rowValues(data) {
+		if cellString(cellAt(cells, i)) != cellString(want) {
+			return false
+		}
+	}
+	return true
+}
+
+// cellString renders a cell or database value canonically so the two can be
+// compared: numbers without trailing zeros, booleans as true/false.
+func cellString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
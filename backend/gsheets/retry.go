@@ -0,0 +1,160 @@
+package gsheets
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy controls how a failed Sheets API call is retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is shared by every SheetManager. MaxAttempts can be set
+// with SHEETS_MAX_ATTEMPTS.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: config.EnvInt("SHEETS_MAX_ATTEMPTS", 5),
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// apiQuota keeps all calls to Google within the per-minute request budget
+// (SHEETS_REQUESTS_PER_MINUTE, default 60 which is Google's per-user limit).
+var apiQuota = newQuota(config.EnvInt("SHEETS_REQUESTS_PER_MINUTE", 60))
+
+// quota is a token bucket refilled continuously at perMinute tokens a minute.
+type quota struct {
+	mu        sync.Mutex
+	perMinute float64
+	tokens    float64
+	last      time.Time
+}
+
+func newQuota(perMinute int) *quota {
+	return &quota{perMinute: float64(perMinute), tokens: float64(perMinute), last: time.Now()}
+}
+
+// wait blocks until a request may be sent. The token is taken under the
+// lock, going below zero if need be, so that concurrent callers queue behind
+// each other while sleeping outside it.
+func (q *quota) wait() {
+	q.mu.Lock()
+	now := time.Now()
+	q.tokens += now.Sub(q.last).Minutes() * q.perMinute
+	if q.tokens > q.perMinute {
+		q.tokens = q.perMinute
+	}
+	q.last = now
+	q.tokens--
+
+	var wait time.Duration
+	if q.tokens < 0 {
+		wait = time.Duration(-q.tokens / q.perMinute * float64(time.Minute))
+	}
+	q.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// do runs an idempotent call under the request budget, retrying rate limits,
+// server errors and network failures with jittered exponential backoff. A
+// Retry-After sent by Google takes precedence over the computed delay.
+func (s *SheetManager) do(op string, call func() error) error {
+	return s.retry(op, call, retryable)
+}
+
+// doOnce runs a call that is not safe to repeat, such as an append or a row
+// delete, retrying only rate limits: Google rejects those before applying
+// anything, while a server or network error may come after the change was
+// made. Callers drop the tab's row index when it fails.
+func (s *SheetManager) doOnce(op string, call func() error) error {
+	return s.retry(op, call, rateLimited)
+}
+
+func (s *SheetManager) retry(op string, call func() error, retryable func(error) bool) error {
+	policy := DefaultRetryPolicy
+
+	for attempt := 1; ; attempt++ {
+		if s.limit != nil {
+			s.limit.wait()
+		}
+
+		err := call()
+		if err == nil {
+			return nil
+		}
+
+		if !retryable(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		delay := retryAfter(err)
+		if delay == 0 {
+			delay = backoff(policy, attempt)
+		}
+
+		log.Printf("Sheets %s failed (attempt %d/%d), retrying in %s: %v", op, attempt, policy.MaxAttempts, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
+
+func retryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func rateLimited(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests
+}
+
+func retryAfter(err error) time.Duration {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0
+	}
+
+	value := apiErr.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// backoff returns a delay between half and all of BaseDelay*2^(attempt-1),
+// capped at MaxDelay.
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	delay := policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
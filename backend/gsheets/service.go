@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
@@ -13,17 +15,134 @@ import (
 )
 
 type SheetManager struct {
-	Service       *sheets.Service
+	Client        Client
 	SpreadsheetID string
+
+	// Annotations remembers the cells Annotate marked.
+	Annotations database.AnnotationStore
+
+	// limit is the request budget calls are kept within, nil for none.
+	limit *quota
+
+	// tabs is keyed by table name.
+	tabs map[string]*Tab
+}
+
+// Tab is a sheet tab together with the table it mirrors.
+type Tab struct {
+	config.TableMapping
+	SheetID int64
+
+	// index maps row IDs to zero-based sheet row indexes. It is nil until
+	// first needed and is rebuilt from the sheet once older than indexTTL or
+	// when a row is no longer where it says.
+	index     map[string]int
+	indexedAt time.Time
 }
 
+// indexTTL is how long a row index is kept before it is re-read from the
+// sheet, which picks up rows added by hand. Rows moved or sorted by hand are
+// caught earlier, positions are checked before every write. Set with
+// SHEET_INDEX_TTL (e.g. "2m").
+var indexTTL = config.EnvDuration("SHEET_INDEX_TTL", 5*time.Minute)
+
 func strPtr(s string) *string {
 	return &s
 }
 
-func (s *SheetManager) InitializeSheet() error {
-	readRange := "Sheet1!A1"
-	resp, err := s.Service.Spreadsheets.Values.Get(s.SpreadsheetID, readRange).Do()
+// columnLetter converts a zero-based column index to its A1 letters.
+func columnLetter(index int) string {
+	letters := ""
+	for index >= 0 {
+		letters = string(rune('A'+index%26)) + letters
+		index = index/26 - 1
+	}
+	return letters
+}
+
+// a1 prefixes an A1 range with the quoted tab name.
+func (t *Tab) a1(r string) string {
+	return fmt.Sprintf("'%s'!%s", strings.ReplaceAll(t.Tab, "'", "''"), r)
+}
+
+func (t *Tab) lastColumn() string {
+	return columnLetter(len(t.Columns) - 1)
+}
+
+// rowValues lays out data in the tab's column order.
+func (t *Tab) rowValues(data map[string]interface{}) []interface{} {
+	values := make([]interface{}, len(t.Columns))
+	for i, c := range t.Columns {
+		values[i] = c.SheetValue(data[c.Name])
+	}
+	return values
+}
+
+func (s *SheetManager) tab(table string) (*Tab, error) {
+	t, ok := s.tabs[table]
+	if !ok {
+		return nil, fmt.Errorf("no tab mapped for table %s", table)
+	}
+	return t, nil
+}
+
+// resolveTabs looks up the sheet ID of every mapped tab, creating the tabs
+// that do not exist yet.
+func (s *SheetManager) resolveTabs(mappings []config.TableMapping) error {
+	var ss *sheets.Spreadsheet
+	err := s.do("read spreadsheet", func() (err error) {
+		ss, err = s.Client.GetSpreadsheet(s.SpreadsheetID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read spreadsheet: %v", err)
+	}
+
+	existing := map[string]int64{}
+	for _, sh := range ss.Sheets {
+		existing[sh.Properties.Title] = sh.Properties.SheetId
+	}
+
+	s.tabs = map[string]*Tab{}
+	for _, m := range mappings {
+		sheetID, ok := existing[m.Tab]
+		if !ok {
+			sheetID, err = s.addTab(m.Tab)
+			if err != nil {
+				return err
+			}
+		}
+		s.tabs[m.Table] = &Tab{TableMapping: m, SheetID: sheetID}
+	}
+	return nil
+}
+
+func (s *SheetManager) addTab(title string) (int64, error) {
+	req := &sheets.Request{
+		AddSheet: &sheets.AddSheetRequest{
+			Properties: &sheets.SheetProperties{Title: title},
+		},
+	}
+
+	var resp *sheets.BatchUpdateSpreadsheetResponse
+	err := s.do("create tab", func() (err error) {
+		resp, err = s.Client.BatchUpdate(s.SpreadsheetID, []*sheets.Request{req})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tab %s: %v", title, err)
+	}
+
+	log.Printf("Created tab %s", title)
+	return resp.Replies[0].AddSheet.Properties.SheetId, nil
+}
+
+func (s *SheetManager) InitializeSheet(t *Tab) error {
+	var resp *sheets.ValueRange
+	err := s.do("check header", func() (err error) {
+		resp, err = s.Client.GetValues(s.SpreadsheetID, t.a1("A1"), "")
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to check sheet status: %v", err)
 	}
@@ -32,26 +151,19 @@ func (s *SheetManager) InitializeSheet() error {
 		return nil
 	}
 
-	log.Println("Sheet appears empty. Initializing headers and formatting...")
+	log.Printf("Tab %s appears empty. Initializing headers and formatting...", t.Tab)
 
 	var requests []*sheets.Request
 
+	header := make([]*sheets.CellData, len(t.Columns))
+	for i, c := range t.Columns {
+		header[i] = &sheets.CellData{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr(c.Header)}}
+	}
+
 	requests = append(requests, &sheets.Request{
 		UpdateCells: &sheets.UpdateCellsRequest{
-			Start: &sheets.GridCoordinate{SheetId: 0, RowIndex: 0, ColumnIndex: 0},
-			Rows: []*sheets.RowData{
-				{
-					Values: []*sheets.CellData{
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("UUID")}},
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("Product Name")}},
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("Quantity")}},
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("Price")}},
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("Discount")}},
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("Last Updated")}},
-						{UserEnteredValue: &sheets.ExtendedValue{StringValue: strPtr("Updated By")}},
-					},
-				},
-			},
+			Start:  &sheets.GridCoordinate{SheetId: t.SheetID, RowIndex: 0, ColumnIndex: 0},
+			Rows:   []*sheets.RowData{{Values: header}},
 			Fields: "userEnteredValue",
 		},
 	})
@@ -59,7 +171,7 @@ func (s *SheetManager) InitializeSheet() error {
 	requests = append(requests, &sheets.Request{
 		RepeatCell: &sheets.RepeatCellRequest{
 			Range: &sheets.GridRange{
-				SheetId:       0,
+				SheetId:       t.SheetID,
 				StartRowIndex: 0, EndRowIndex: 1,
 			},
 			Cell: &sheets.CellData{
@@ -76,49 +188,47 @@ func (s *SheetManager) InitializeSheet() error {
 	requests = append(requests, &sheets.Request{
 		UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
 			Properties: &sheets.SheetProperties{
-				SheetId:        0,
+				SheetId:        t.SheetID,
 				GridProperties: &sheets.GridProperties{FrozenRowCount: 1},
 			},
 			Fields: "gridProperties.frozenRowCount",
 		},
 	})
 
-	requests = append(requests, &sheets.Request{
-		RepeatCell: &sheets.RepeatCellRequest{
-			Range: &sheets.GridRange{
-				SheetId:          0,
-				StartColumnIndex: 3, EndColumnIndex: 4,
-				StartRowIndex: 1,
-			},
-			Cell: &sheets.CellData{
-				UserEnteredFormat: &sheets.CellFormat{
-					NumberFormat: &sheets.NumberFormat{Type: "CURRENCY", Pattern: "$#,##0.00"},
+	for i, c := range t.Columns {
+		format := &sheets.CellFormat{HorizontalAlignment: c.Align}
+		var fields []string
+		if c.Align != "" {
+			fields = append(fields, "userEnteredFormat.horizontalAlignment")
+		}
+		if c.NumberFormat != nil {
+			format.NumberFormat = &sheets.NumberFormat{Type: c.NumberFormat.Type, Pattern: c.NumberFormat.Pattern}
+			fields = append(fields, "userEnteredFormat.numberFormat")
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		requests = append(requests, &sheets.Request{
+			RepeatCell: &sheets.RepeatCellRequest{
+				Range: &sheets.GridRange{
+					SheetId:          t.SheetID,
+					StartColumnIndex: int64(i), EndColumnIndex: int64(i + 1),
+					StartRowIndex: 1,
 				},
+				Cell:   &sheets.CellData{UserEnteredFormat: format},
+				Fields: strings.Join(fields, ","),
 			},
-			Fields: "userEnteredFormat.numberFormat",
-		},
-	})
+		})
+	}
 
-	requests = append(requests, &sheets.Request{
-		RepeatCell: &sheets.RepeatCellRequest{
-			Range: &sheets.GridRange{
-				SheetId:          0,
-				StartColumnIndex: 2, EndColumnIndex: 3,
-				StartRowIndex: 1,
-			},
-			Cell: &sheets.CellData{
-				UserEnteredFormat: &sheets.CellFormat{HorizontalAlignment: "CENTER"},
-			},
-			Fields: "userEnteredFormat.horizontalAlignment",
-		},
+	return s.do("initialize tab", func() error {
+		_, err := s.Client.BatchUpdate(s.SpreadsheetID, requests)
+		return err
 	})
-
-	batchReq := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
-	_, err = s.Service.Spreadsheets.BatchUpdate(s.SpreadsheetID, batchReq).Do()
-	return err
 }
 
-func NewSheetManager(spreadsheetID string) (*SheetManager, error) {
+func NewSheetManager(spreadsheetID string, mappings []config.TableMapping) (*SheetManager, error) {
 	ctx := context.Background()
 
 	token, err := database.GetLatestToken()
@@ -135,170 +245,335 @@ func NewSheetManager(spreadsheetID string) (*SheetManager, error) {
 		return nil, err
 	}
 
+	return newSheetManager(NewClient(srv), apiQuota, database.MySQLAnnotations{}, spreadsheetID, mappings)
+}
+
+// NewSheetManagerWithClient is NewSheetManager for another Sheets backend,
+// such as a gsheetstest.FakeSheets server. Its calls are not rate limited
+// and annotated cells are remembered in memory.
+func NewSheetManagerWithClient(client Client, spreadsheetID string, mappings []config.TableMapping) (*SheetManager, error) {
+	return newSheetManager(client, nil, database.NewMemoryAnnotations(), spreadsheetID, mappings)
+}
+
+func newSheetManager(client Client, limit *quota, annotations database.AnnotationStore, spreadsheetID string, mappings []config.TableMapping) (*SheetManager, error) {
 	sm := &SheetManager{
-		Service:       srv,
+		Client:        client,
 		SpreadsheetID: spreadsheetID,
+		Annotations:   annotations,
+		limit:         limit,
+	}
+
+	if err := sm.resolveTabs(mappings); err != nil {
+		return nil, err
 	}
 
-	if err := sm.InitializeSheet(); err != nil {
-		log.Printf("Warning: Failed to initialize sheet headers: %v", err)
+	for _, t := range sm.tabs {
+		if err := sm.InitializeSheet(t); err != nil {
+			log.Printf("Warning: Failed to initialize headers for tab %s: %v", t.Tab, err)
+		}
 	}
 
 	return sm, nil
 }
 
-func (s *SheetManager) findRowIndex(uuid string) (int, error) {
-	readRange := "Sheet1!A:A"
-	resp, err := s.Service.Spreadsheets.Values.Get(s.SpreadsheetID, readRange).Do()
+// loadIndex reads the ID column of the tab and rebuilds its row index.
+func (s *SheetManager) loadIndex(t *Tab) error {
+	var resp *sheets.ValueRange
+	err := s.do("read row index", func() (err error) {
+		resp, err = s.Client.GetValues(s.SpreadsheetID, t.a1("A:A"), "")
+		return err
+	})
 	if err != nil {
-		return -1, fmt.Errorf("failed to read sheet for lookup: %v", err)
+		return fmt.Errorf("failed to read sheet for lookup: %v", err)
 	}
 
+	index := make(map[string]int, len(resp.Values))
 	for i, row := range resp.Values {
-		if len(row) > 0 && row[0] == uuid {
-			return i, nil
+		if i == 0 || len(row) == 0 {
+			continue
 		}
+		index[fmt.Sprintf("%v", row[0])] = i
 	}
-	return -1, nil
-}
 
-func (s *SheetManager) SyncToSheet(uuid string, data map[string]interface{}) error {
+	if t.index != nil {
+		moved := 0
+		for id, i := range index {
+			if old, ok := t.index[id]; !ok || old != i {
+				moved++
+			}
+		}
+		if moved > 0 || len(index) != len(t.index) {
+			log.Printf("Row index of %s was stale (%d rows moved or added outside sync), rebuilt", t.Tab, moved)
+		}
+	}
+
+	t.index = index
+	t.indexedAt = time.Now()
+	return nil
+}
 
-	index, err := s.findRowIndex(uuid)
+func (s *SheetManager) findRowIndex(t *Tab, id string) (int, error) {
+	rows, err := s.rowIndexes(t, []string{id})
 	if err != nil {
+		return -1, err
+	}
+	return rows[id], nil
+}
+
+// rowIndexes returns the zero-based rows of ids, -1 for those not in the
+// tab. Cached positions are checked against the ID column before they are
+// used, a row inserted or sorted by hand since the index was built would
+// otherwise get another row overwritten or deleted in its place.
+func (s *SheetManager) rowIndexes(t *Tab, ids []string) (map[string]int, error) {
+	if t.index == nil || time.Since(t.indexedAt) > indexTTL {
+		if err := s.loadIndex(t); err != nil {
+			return nil, err
+		}
+	} else if err := s.checkIndex(t, ids); err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]int, len(ids))
+	for _, id := range ids {
+		if i, ok := t.index[id]; ok {
+			rows[id] = i
+		} else {
+			rows[id] = -1
+		}
+	}
+	return rows, nil
+}
+
+// checkIndex reads the ID column over the cached rows of ids and rebuilds
+// the index if any of them holds another ID.
+func (s *SheetManager) checkIndex(t *Tab, ids []string) error {
+	first, last := -1, -1
+	for _, id := range ids {
+		i, ok := t.index[id]
+		if !ok {
+			continue
+		}
+		if first == -1 || i < first {
+			first = i
+		}
+		if i > last {
+			last = i
+		}
+	}
+	if first == -1 {
+		return nil
+	}
+
+	var resp *sheets.ValueRange
+	err := s.do("check row index", func() (err error) {
+		resp, err = s.Client.GetValues(s.SpreadsheetID, t.a1(fmt.Sprintf("A%d:A%d", first+1, last+1)), "")
 		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check row index: %v", err)
 	}
 
-	if index == -1 {
-		return s.appendRow(uuid, data)
+	for _, id := range ids {
+		i, ok := t.index[id]
+		if !ok {
+			continue
+		}
+		var cell []interface{}
+		if i-first < len(resp.Values) {
+			cell = resp.Values[i-first]
+		}
+		if len(cell) == 0 || fmt.Sprintf("%v", cell[0]) != id {
+			return s.loadIndex(t)
+		}
 	}
+	return nil
+}
 
-	rowNum := index + 1
-	writeRange := fmt.Sprintf("Sheet1!B%d:G%d", rowNum, rowNum)
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
+// removeFromIndex drops a deleted row and shifts the rows below it up.
+func (t *Tab) removeFromIndex(id string, deleted int) {
+	delete(t.index, id)
+	for other, i := range t.index {
+		if i > deleted {
+			t.index[other] = i - 1
+		}
+	}
+}
+
+// ForgetRows updates the row index for rows deleted in the sheet directly
+// rather than through the SheetManager, so the rows below them are not
+// written one row too low.
+func (s *SheetManager) ForgetRows(table string, ids []string) {
+	t, err := s.tab(table)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		if i, ok := t.index[id]; ok {
+			t.removeFromIndex(id, i)
+		}
+	}
+}
 
-	updatedBy, ok := data["last_updated_by"].(string)
-	if !ok || updatedBy == "" {
-		updatedBy = "system"
+// rowFromRange returns the zero-based row of the first cell in an A1 range
+// such as "'Sheet1'!A5:G5".
+func rowFromRange(a1 string) (int, bool) {
+	cells := a1[strings.LastIndex(a1, "!")+1:]
+	cells = strings.SplitN(cells, ":", 2)[0]
+	digits := strings.TrimLeft(cells, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+	row, err := strconv.Atoi(digits)
+	if err != nil || row < 1 {
+		return 0, false
+	}
+	return row - 1, true
+}
+
+func (s *SheetManager) SyncToSheet(table, id string, data map[string]interface{}) error {
+	t, err := s.tab(table)
+	if err != nil {
+		return err
 	}
 
-	values := []interface{}{
-		data["product_name"],
-		data["quantity"],
-		data["price"],
-		data["discount"],
-		timestamp,
-		updatedBy,
+	index, err := s.findRowIndex(t, id)
+	if err != nil {
+		return err
+	}
+
+	if index == -1 {
+		return s.appendRow(t, id, data)
 	}
 
+	rowNum := index + 1
+	writeRange := t.a1(fmt.Sprintf("A%d:%s%d", rowNum, t.lastColumn(), rowNum))
+
 	valRange := &sheets.ValueRange{
-		Values: [][]interface{}{values},
+		Values: [][]interface{}{t.rowValues(data)},
 	}
 
-	_, err = s.Service.Spreadsheets.Values.Update(s.SpreadsheetID, writeRange, valRange).ValueInputOption("RAW").Do()
+	err = s.do("update row", func() error {
+		return s.Client.UpdateValues(s.SpreadsheetID, writeRange, valRange)
+	})
 
 	if err == nil {
-		log.Printf("Synced row %d in Sheets for UUID %s (Updated By: %s)", rowNum, uuid, updatedBy)
+		log.Printf("Synced row %d in %s for %s (Updated By: %v)", rowNum, t.Tab, id, data["last_updated_by"])
 	}
 	return err
 }
 
-func (s *SheetManager) DeleteRow(uuid string) error {
-	index, err := s.findRowIndex(uuid)
+func (s *SheetManager) DeleteRow(table, id string) error {
+	t, err := s.tab(table)
+	if err != nil {
+		return err
+	}
+
+	index, err := s.findRowIndex(t, id)
 	if err != nil {
 		return err
 	}
 
 	if index == -1 {
-		log.Printf("UUID %s not found in sheets, skipping delete.", uuid)
+		log.Printf("%s not found in %s, skipping delete.", id, t.Tab)
 		return nil
 	}
 
-	req := &sheets.Request{
-		DeleteDimension: &sheets.DeleteDimensionRequest{
-			Range: &sheets.DimensionRange{
-				SheetId:    0,
-				Dimension:  "ROWS",
-				StartIndex: int64(index),
-				EndIndex:   int64(index + 1),
-			},
-		},
+	if err := s.deleteRowsAt(t, []int{index}); err != nil {
+		return err
 	}
 
-	batchReq := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: []*sheets.Request{req},
+	t.removeFromIndex(id, index)
+	log.Printf("Deleted row %d in %s for %s", index+1, t.Tab, id)
+	return nil
+}
+
+// deleteRowsAt removes rows by zero-based index, given in descending order
+// so that each delete leaves the remaining indexes valid.
+func (s *SheetManager) deleteRowsAt(t *Tab, indexes []int) error {
+	var requests []*sheets.Request
+	for _, index := range indexes {
+		requests = append(requests, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    t.SheetID,
+					Dimension:  "ROWS",
+					StartIndex: int64(index),
+					EndIndex:   int64(index + 1),
+				},
+			},
+		})
 	}
 
-	_, err = s.Service.Spreadsheets.BatchUpdate(s.SpreadsheetID, batchReq).Do()
-	if err == nil {
-		log.Printf("Deleted row %d for UUID %s", index+1, uuid)
+	err := s.doOnce("delete row", func() error {
+		_, err := s.Client.BatchUpdate(s.SpreadsheetID, requests)
+		return err
+	})
+	if err != nil {
+		// The rows may be gone all the same, find them again by ID.
+		t.index = nil
 	}
 	return err
 }
 
-func (s *SheetManager) appendRow(uuid string, data map[string]interface{}) error {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-
-	updatedBy, ok := data["last_updated_by"].(string)
-	if !ok || updatedBy == "" {
-		updatedBy = "system"
+func (s *SheetManager) appendRow(t *Tab, id string, data map[string]interface{}) error {
+	valRange := &sheets.ValueRange{
+		Values: [][]interface{}{t.rowValues(data)},
 	}
 
-	values := []interface{}{
-		uuid,
-		data["product_name"],
-		data["quantity"],
-		data["price"],
-		data["discount"],
-		timestamp,
-		updatedBy,
+	var resp *sheets.AppendValuesResponse
+	err := s.doOnce("append row", func() (err error) {
+		resp, err = s.Client.AppendValues(s.SpreadsheetID, t.a1("A1"), valRange)
+		return err
+	})
+	if err != nil {
+		// The row may have been added all the same; a reloaded index finds
+		// it so the next attempt updates it instead of appending again.
+		t.index = nil
+		return err
 	}
 
-	valRange := &sheets.ValueRange{
-		Values: [][]interface{}{values},
+	if row, ok := rowFromRange(resp.Updates.UpdatedRange); ok && t.index != nil {
+		t.index[id] = row
+	} else {
+		t.index = nil
 	}
-
-	_, err := s.Service.Spreadsheets.Values.Append(s.SpreadsheetID, "Sheet1!A1", valRange).ValueInputOption("RAW").Do()
-	return err
+	return nil
 }
 
-func (s *SheetManager) ClearAndOverwrite(products []map[string]interface{}) error {
+func (s *SheetManager) ClearAndOverwrite(table string, rows []map[string]interface{}) error {
+	t, err := s.tab(table)
+	if err != nil {
+		return err
+	}
 
-	clearRange := "Sheet1!A2:Z"
-	_, err := s.Service.Spreadsheets.Values.Clear(s.SpreadsheetID, clearRange, &sheets.ClearValuesRequest{}).Do()
+	clearRange := t.a1("A2:" + t.lastColumn())
+	err = s.do("clear tab", func() error {
+		return s.Client.ClearValues(s.SpreadsheetID, clearRange)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to clear sheet: %v", err)
 	}
 
 	var valueRange sheets.ValueRange
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-
-	for _, p := range products {
-
-		updatedBy := "initial_sync"
-		if val, ok := p["last_updated_by"].(string); ok && val != "" {
-			updatedBy = val
-		}
-
-		row := []interface{}{
-			p["uuid"],
-			p["product_name"],
-			p["quantity"],
-			p["price"],
-			p["discount"],
-			timestamp,
-			updatedBy,
-		}
-		valueRange.Values = append(valueRange.Values, row)
+	index := make(map[string]int, len(rows))
+	for i, row := range rows {
+		valueRange.Values = append(valueRange.Values, t.rowValues(row))
+		index[fmt.Sprintf("%v", row[t.PrimaryKey])] = i + 1
 	}
 
+	t.index = nil
 	if len(valueRange.Values) == 0 {
 		return nil
 	}
 
-	writeRange := "Sheet1!A2"
-	_, err = s.Service.Spreadsheets.Values.Update(s.SpreadsheetID, writeRange, &valueRange).ValueInputOption("RAW").Do()
+	err = s.do("overwrite tab", func() error {
+		return s.Client.UpdateValues(s.SpreadsheetID, t.a1("A2"), &valueRange)
+	})
+	if err != nil {
+		return err
+	}
 
-	log.Printf("Successfully performed Initial Sync of %d products", len(products))
-	return err
+	t.index = index
+	t.indexedAt = time.Now()
+
+	log.Printf("Successfully performed Initial Sync of %d rows into %s", len(rows), t.Tab)
+	return nil
 }
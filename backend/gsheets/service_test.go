@@ -0,0 +1,290 @@
+package gsheets_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets/gsheetstest"
+)
+
+var testMapping = config.TableMapping{
+	Table:      "product",
+	PrimaryKey: "uuid",
+	Tab:        "Sheet1",
+	Columns: []config.Column{
+		{Name: "uuid", Header: "UUID", Type: config.TypeString, ReadOnly: true},
+		{Name: "product_name", Header: "Product Name", Type: config.TypeString},
+		{Name: "quantity", Header: "Quantity", Type: config.TypeInt},
+	},
+}
+
+var testHeader = []interface{}{"UUID", "Product Name", "Quantity"}
+
+func product(uuid, name string, quantity int64) map[string]interface{} {
+	return map[string]interface{}{"uuid": uuid, "product_name": name, "quantity": quantity}
+}
+
+// newSheet starts a fake spreadsheet holding rows under the header and a
+// SheetManager for it.
+func newSheet(t *testing.T, rows ...[]interface{}) (*gsheetstest.FakeSheets, *gsheets.SheetManager) {
+	t.Helper()
+
+	f := gsheetstest.NewFakeSheets(testMapping.Tab)
+	t.Cleanup(f.Close)
+	f.SetValues(testMapping.Tab, append([][]interface{}{testHeader}, rows...))
+
+	sm, err := f.SheetManager([]config.TableMapping{testMapping})
+	if err != nil {
+		t.Fatalf("SheetManager: %v", err)
+	}
+	return f, sm
+}
+
+func expectValues(t *testing.T, f *gsheetstest.FakeSheets, want ...[]interface{}) {
+	t.Helper()
+	want = append([][]interface{}{testHeader}, want...)
+	if got := f.Values(testMapping.Tab); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sheet is\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestInitializeSheetWritesHeader(t *testing.T) {
+	f := gsheetstest.NewFakeSheets(testMapping.Tab)
+	defer f.Close()
+
+	// Creating the manager initializes every tab.
+	if _, err := f.SheetManager([]config.TableMapping{testMapping}); err != nil {
+		t.Fatalf("SheetManager: %v", err)
+	}
+	expectValues(t, f)
+}
+
+func TestInitializeSheetKeepsExistingHeader(t *testing.T) {
+	f, sm := newSheet(t)
+	f.SetCell(testMapping.Tab, 0, 1, "Name")
+
+	if err := sm.InitializeSheet(&gsheets.Tab{TableMapping: testMapping}); err != nil {
+		t.Fatalf("InitializeSheet: %v", err)
+	}
+	if got := f.Values(testMapping.Tab)[0][1]; got != "Name" {
+		t.Fatalf("header overwritten with %v", got)
+	}
+}
+
+func TestInitializeSheetError(t *testing.T) {
+	f, sm := newSheet(t)
+	f.Fail(400)
+
+	if err := sm.InitializeSheet(&gsheets.Tab{TableMapping: testMapping}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSyncToSheetUpdatesRow(t *testing.T) {
+	f, sm := newSheet(t,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+	)
+
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Mechanical Keyboard", 25)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Mechanical Keyboard", 25.0},
+	)
+}
+
+func TestSyncToSheetAppendsNewRow(t *testing.T) {
+	f, sm := newSheet(t, []interface{}{"u-101", "Gaming Mouse", 50.0})
+
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Keyboard", 30)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	// The row index learns where the append landed.
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Keyboard", 29)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 29.0},
+	)
+}
+
+func TestSyncToSheetUnknownTable(t *testing.T) {
+	_, sm := newSheet(t)
+
+	if err := sm.SyncToSheet("orders", "1", map[string]interface{}{}); err == nil {
+		t.Fatal("expected an error for a table without a tab")
+	}
+}
+
+func TestDeleteRow(t *testing.T) {
+	f, sm := newSheet(t,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+		[]interface{}{"u-103", "USB-C Cable", 100.0},
+	)
+
+	if err := sm.DeleteRow("product", "u-101"); err != nil {
+		t.Fatalf("DeleteRow: %v", err)
+	}
+	// The rows below moved up, u-103 must still be found.
+	if err := sm.SyncToSheet("product", "u-103", product("u-103", "USB-C Cable", 99)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-102", "Keyboard", 30.0},
+		[]interface{}{"u-103", "USB-C Cable", 99.0},
+	)
+}
+
+func TestDeleteRowMissing(t *testing.T) {
+	f, sm := newSheet(t, []interface{}{"u-101", "Gaming Mouse", 50.0})
+
+	if err := sm.DeleteRow("product", "u-999"); err != nil {
+		t.Fatalf("DeleteRow: %v", err)
+	}
+	expectValues(t, f, []interface{}{"u-101", "Gaming Mouse", 50.0})
+}
+
+func TestAppendAndDeleteNotRetriedOnServerError(t *testing.T) {
+	f, sm := newSheet(t, []interface{}{"u-101", "Gaming Mouse", 50.0})
+
+	// Google may have applied a call that failed with a 5xx, repeating an
+	// append or delete could duplicate the row or delete the next one.
+	f.FailWrites(503)
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Keyboard", 30)); err == nil {
+		t.Fatal("append retried after a server error")
+	}
+	f.FailWrites(503)
+	if err := sm.DeleteRow("product", "u-101"); err == nil {
+		t.Fatal("delete retried after a server error")
+	}
+	expectValues(t, f, []interface{}{"u-101", "Gaming Mouse", 50.0})
+
+	// A rate limit means nothing was applied.
+	f.FailWrites(429)
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Keyboard", 30)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+	)
+}
+
+func TestClearAndOverwrite(t *testing.T) {
+	f, sm := newSheet(t,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+		[]interface{}{"u-103", "USB-C Cable", 100.0},
+	)
+
+	rows := []map[string]interface{}{
+		product("u-103", "USB-C Cable", 90),
+		product("u-104", "Monitor", 5),
+	}
+	if err := sm.ClearAndOverwrite("product", rows); err != nil {
+		t.Fatalf("ClearAndOverwrite: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-103", "USB-C Cable", 90.0},
+		[]interface{}{"u-104", "Monitor", 5.0},
+	)
+
+	// The index points at the new positions.
+	if err := sm.SyncToSheet("product", "u-104", product("u-104", "Monitor", 4)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-103", "USB-C Cable", 90.0},
+		[]interface{}{"u-104", "Monitor", 4.0},
+	)
+}
+
+func TestClearAndOverwriteEmpty(t *testing.T) {
+	f, sm := newSheet(t, []interface{}{"u-101", "Gaming Mouse", 50.0})
+
+	if err := sm.ClearAndOverwrite("product", nil); err != nil {
+		t.Fatalf("ClearAndOverwrite: %v", err)
+	}
+	expectValues(t, f)
+}
+
+func TestSyncToSheetRowMovedByHand(t *testing.T) {
+	f, sm := newSheet(t,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+	)
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Keyboard", 29)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+
+	// Sorted by hand while the index still has u-102 in row 3.
+	f.SetValues(testMapping.Tab, [][]interface{}{
+		testHeader,
+		{"u-102", "Keyboard", 29.0},
+		{"u-101", "Gaming Mouse", 50.0},
+	})
+	if err := sm.SyncToSheet("product", "u-102", product("u-102", "Keyboard", 28)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-102", "Keyboard", 28.0},
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+	)
+}
+
+func TestDeleteRowInsertedAboveByHand(t *testing.T) {
+	f, sm := newSheet(t,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+	)
+	if err := sm.SyncToSheet("product", "u-101", product("u-101", "Gaming Mouse", 50)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+
+	// A row inserted at the top pushes u-101 down.
+	f.SetValues(testMapping.Tab, [][]interface{}{
+		testHeader,
+		{"u-100", "Webcam", 3.0},
+		{"u-101", "Gaming Mouse", 50.0},
+		{"u-102", "Keyboard", 30.0},
+	})
+	if err := sm.DeleteRow("product", "u-101"); err != nil {
+		t.Fatalf("DeleteRow: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-100", "Webcam", 3.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+	)
+}
+
+func TestApplyBatchIndexesAppendedRows(t *testing.T) {
+	f, sm := newSheet(t,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		// A row without an ID is not indexed but appends still go below it.
+		[]interface{}{"", "Spare", 1.0},
+	)
+
+	appendRows := []cdc.SyncEvent{
+		{Table: "product", RowID: "u-102", Action: "insert", Data: product("u-102", "Keyboard", 30)},
+		{Table: "product", RowID: "u-103", Action: "insert", Data: product("u-103", "USB-C Cable", 100)},
+	}
+	if err := sm.ApplyBatch(appendRows); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if err := sm.SyncToSheet("product", "u-103", product("u-103", "USB-C Cable", 99)); err != nil {
+		t.Fatalf("SyncToSheet: %v", err)
+	}
+	expectValues(t, f,
+		[]interface{}{"u-101", "Gaming Mouse", 50.0},
+		[]interface{}{"", "Spare", 1.0},
+		[]interface{}{"u-102", "Keyboard", 30.0},
+		[]interface{}{"u-103", "USB-C Cable", 99.0},
+	)
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AdminOnly only lets requests through to next that carry ADMIN_TOKEN as a
+// bearer token ("Authorization: Bearer <token>"). Anything else gets a 401,
+// everything does while ADMIN_TOKEN is not set.
+func AdminOnly(next http.HandlerFunc) http.HandlerFunc {
+	token := []byte(strings.TrimSpace(os.Getenv("ADMIN_TOKEN")))
+	if len(token) == 0 {
+		log.Println("WARNING: ADMIN_TOKEN is not set, all admin API requests will be rejected")
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || len(token) == 0 || subtle.ConstantTimeCompare([]byte(got), token) != 1 {
+			log.Printf("Admin request to %s rejected from %s", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
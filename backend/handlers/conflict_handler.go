@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// 1. GET /api/admin/conflicts?uuid=u-101
+func ListConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 500
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	conflicts, err := database.ListConflicts(r.URL.Query().Get("uuid"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if conflicts == nil {
+		conflicts = []database.Conflict{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conflicts)
+}
+
+// requestVersion returns the product version a REST client based its change
+// on, taken from the If-Match header or a "version" field in the body.
+func requestVersion(r *http.Request, body map[string]interface{}) (int64, bool) {
+	if tag := r.Header.Get("If-Match"); tag != "" {
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		if n, err := strconv.ParseInt(tag, 10, 64); err == nil {
+			return n, true
+		}
+	}
+
+	switch v := body[database.VersionColumn].(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// 1. GET /api/admin/drift?table=product
+func ListDriftHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 500
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	discrepancies, err := database.ListDiscrepancies(r.URL.Query().Get("table"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if discrepancies == nil {
+		discrepancies = []database.Discrepancy{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discrepancies)
+}
+
+// 2. POST /api/admin/drift/check
+// Runs a drift check now instead of waiting for the next scheduled one. The
+// check itself is done by the worker, results show up in GET /api/admin/drift.
+func CheckDriftHandler(w http.ResponseWriter, r *http.Request, checkSignal chan<- struct{}) {
+	select {
+	case checkSignal <- struct{}{}:
+	default:
+		// A check is already pending.
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Drift check scheduled"))
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// 1. GET /api/products/{uuid}/history?limit=100
+// Lists the changes to one product, newest first, one entry per field.
+func ProductHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 || parts[3] == "" {
+		http.Error(w, "Missing UUID", http.StatusBadRequest)
+		return
+	}
+	id := parts[3]
+
+	limit := 100
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	changes, err := database.ListChanges("product", id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if changes == nil {
+		changes = []database.Change{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// 1. GET /api/admin/outbox?status=failed
+func ListOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	limit := 500
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = n
+	}
+
+	entries, err := database.ListOutbox(status, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if entries == nil {
+		entries = []database.OutboxEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// 2. POST /api/admin/outbox/{id}/retry
+// POST /api/admin/outbox/retry retries every failed entry.
+// The worker is woken through retrySignal instead of waiting for its next tick.
+func RetryOutboxHandler(w http.ResponseWriter, r *http.Request, retrySignal chan<- struct{}) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// api/admin/outbox/retry or api/admin/outbox/{id}/retry
+	if len(parts) < 4 || parts[len(parts)-1] != "retry" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	var message string
+	if len(parts) == 4 {
+		n, err := database.RetryAllOutbox(database.OutboxFailed)
+		if err != nil {
+			http.Error(w, "Retry failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		message = fmt.Sprintf("Requeued %d entries", n)
+	} else {
+		id, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid outbox ID", http.StatusBadRequest)
+			return
+		}
+
+		found, err := database.RetryOutboxEntry(id)
+		if err != nil {
+			http.Error(w, "Retry failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Outbox entry not found", http.StatusNotFound)
+			return
+		}
+		message = "Requeued"
+	}
+
+	select {
+	case retrySignal <- struct{}{}:
+	default:
+		// A retry is already pending.
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+}
+
+// 3. DELETE /api/admin/outbox/{id}
+func DiscardOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 {
+		http.Error(w, "Missing outbox ID", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid outbox ID", http.StatusBadRequest)
+		return
+	}
+
+	found, err := database.DiscardOutboxEntry(id)
+	if err != nil {
+		http.Error(w, "Discard failed", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Outbox entry not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Discarded"))
+}
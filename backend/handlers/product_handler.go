@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,18 +11,18 @@ import (
 	"github.com/google/uuid"
 )
 
-// Product Struct (matches DB)
-type Product struct {
-	UUID        string  `json:"uuid"`
-	ProductName string  `json:"product_name"`
-	Quantity    int     `json:"quantity"`
-	Price       float64 `json:"price"`
-	Discount    bool    `json:"discount"`
+// Products is where the handlers read and write products. It is set by main,
+// tests can use a database.MemoryProducts.
+var Products database.ProductRepository
+
+// apiWrite is how REST clients are recorded as authors.
+func apiWrite() database.ProductWrite {
+	return database.ProductWrite{Origin: database.OriginAPI, Author: "system", Policy: database.ConflictPolicy()}
 }
 
 // 1. GET /api/products
 func GetProductsHandler(w http.ResponseWriter, r *http.Request) {
-	products, err := database.GetAllProducts()
+	products, err := Products.List(false)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -29,7 +30,7 @@ func GetProductsHandler(w http.ResponseWriter, r *http.Request) {
 
 	// If nil (empty DB), return empty array [] instead of null
 	if products == nil {
-		products = []map[string]interface{}{}
+		products = []database.Product{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -38,26 +39,24 @@ func GetProductsHandler(w http.ResponseWriter, r *http.Request) {
 
 // 2. POST /api/products
 func CreateProductHandler(w http.ResponseWriter, r *http.Request) {
-	var p Product
+	var p database.Product
 	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
 	// Generate UUID if not present
-	newUUID := "u-" + uuid.New().String()[:8]
-
-	// FIX: Explicitly set last_updated_by to 'system'
-	query := "INSERT INTO product (uuid, product_name, quantity, price, discount, last_updated_by) VALUES (?, ?, ?, ?, ?, ?)"
-	_, err := database.DB.Exec(query, newUUID, p.ProductName, p.Quantity, p.Price, p.Discount, "system")
+	p.UUID = "u-" + uuid.New().String()[:8]
 
+	created, err := Products.Create(p, apiWrite())
 	if err != nil {
 		http.Error(w, "Failed to insert product: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, created.Version))
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "Product created with UUID: %s", newUUID)
+	fmt.Fprintf(w, "Product created with UUID: %s", created.UUID)
 }
 
 // 3. PUT /api/products/{uuid}
@@ -78,48 +77,53 @@ func UpdateProductHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Dynamically build query
-	query := "UPDATE product SET "
-	args := []interface{}{}
-
-	hasUpdates := false
+	// whitelist allowed columns, anything else (e.g. "version") is ignored
+	fields := map[string]interface{}{}
 	for key, val := range updates {
-		// whitelist allowed columns to prevent SQL injection via keys
-		if key == "product_name" || key == "quantity" || key == "price" || key == "discount" {
-			query += fmt.Sprintf("%s = ?, ", key)
-			args = append(args, val)
-			hasUpdates = true
+		if database.ProductFieldWritable(key) {
+			fields[key] = val
 		}
 	}
 
-	if !hasUpdates {
+	if len(fields) == 0 {
 		http.Error(w, "No valid fields to update", http.StatusBadRequest)
 		return
 	}
 
-	// --- FIX START ---
-	// Force the update to be attributed to "system"
-	// We append this to the query so the DB knows who did it.
-	query += "last_updated_by = ?, "
-	args = append(args, "system")
-	// --- FIX END ---
-
-	// Remove trailing comma
-	query = strings.TrimSuffix(query, ", ")
-	query += " WHERE uuid = ?"
-	args = append(args, id)
+	// The version the client last saw, if it sent one.
+	write := apiWrite()
+	if base, ok := requestVersion(r, updates); ok {
+		write.BaseVersion = &base
+	}
 
-	_, err := database.DB.Exec(query, args...)
-	if err != nil {
+	updated, err := Products.Patch(id, fields, write)
+	var conflict *database.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, conflict.Current.Version))
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   conflict.Error(),
+			"current": conflict.Current,
+		})
+		return
+	case errors.Is(err, database.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	case err != nil:
 		http.Error(w, "Update failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Lets the client send the new version with its next change.
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, updated.Version))
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Updated"))
 }
 
 // 4. DELETE /api/products/{uuid}
+// Moves the product to the trash, it can be restored from there.
 func DeleteProductHandler(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 4 {
@@ -128,8 +132,7 @@ func DeleteProductHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	id := parts[3]
 
-	_, err := database.DB.Exec("DELETE FROM product WHERE uuid = ?", id)
-	if err != nil {
+	if err := Products.Delete(id, apiWrite()); err != nil {
 		http.Error(w, "Delete failed", http.StatusInternalServerError)
 		return
 	}
@@ -137,3 +140,44 @@ func DeleteProductHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Deleted"))
 }
+
+// 5. GET /api/products/trash
+func ListTrashHandler(w http.ResponseWriter, r *http.Request) {
+	products, err := Products.List(true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if products == nil {
+		products = []database.Product{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(products)
+}
+
+// 6. POST /api/products/{uuid}/restore
+// Takes the product out of the trash. The CDC listener sees deleted_at
+// cleared and adds the row back to the sheet.
+func RestoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 || parts[3] == "" {
+		http.Error(w, "Missing UUID", http.StatusBadRequest)
+		return
+	}
+	id := parts[3]
+
+	err := Products.Restore(id, apiWrite())
+	if errors.Is(err, database.ErrProductNotFound) {
+		http.Error(w, "Product not found in trash", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Restore failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Restored"))
+}
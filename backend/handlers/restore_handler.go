@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// RestoreResponse lists what a point-in-time restore changed, with the
+// outcome of each change, or would change for a dry run.
+type RestoreResponse struct {
+	At      time.Time                `json:"at"`
+	DryRun  bool                     `json:"dry_run"`
+	Changes []database.RestoreChange `json:"changes"`
+}
+
+// 1. POST /api/admin/restore?at=2026-01-02T15:04:05Z&uuid=u-101&apply=true
+// Brings one product, or every product without uuid, back to how it was at
+// the given time. Without apply=true it is a dry run listing the changes.
+func RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	at, err := database.ParseRestoreTime(r.URL.Query().Get("at"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("apply") != "true"
+
+	mapping, ok := config.MappingForTable("product")
+	if !ok {
+		http.Error(w, "product table is not synced", http.StatusInternalServerError)
+		return
+	}
+
+	changes, err := database.PlanRestore(mapping, at, r.URL.Query().Get("uuid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun {
+		database.ApplyRestore(Products, mapping, changes)
+		log.Printf("Restored %d of %d products to %s", restored(changes), len(changes), at.Format(time.RFC3339))
+	}
+
+	if changes == nil {
+		changes = []database.RestoreChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RestoreResponse{At: at, DryRun: dryRun, Changes: changes})
+}
+
+// restored counts the changes that were applied.
+func restored(changes []database.RestoreChange) int {
+	n := 0
+	for _, c := range changes {
+		if c.Status == database.EditApplied {
+			n++
+		}
+	}
+	return n
+}
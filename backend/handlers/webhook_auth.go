@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+)
+
+// Headers carrying the webhook signature. X-Signature is the hex HMAC-SHA256
+// of "<X-Timestamp>.<X-Delivery-ID>.<query>.<body>", X-Timestamp the unix
+// time the request was signed. The query is canonicalQuery of the URL's, so
+// neither the delivery ID nor ?atomic can be changed on a captured request.
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+)
+
+// SignedWebhook only lets requests through to next that are signed with one
+// of the secrets in WEBHOOK_SECRETS (comma separated, so a new secret can be
+// added before the old one is removed) and were signed within
+// WEBHOOK_REPLAY_WINDOW. Anything else gets a 401.
+func SignedWebhook(next http.HandlerFunc) http.HandlerFunc {
+	var secrets [][]byte
+	for _, s := range strings.Split(os.Getenv("WEBHOOK_SECRETS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			secrets = append(secrets, []byte(s))
+		}
+	}
+	if len(secrets) == 0 {
+		log.Println("WARNING: WEBHOOK_SECRETS is not set, all sheet webhook requests will be rejected")
+	}
+
+	window := config.EnvDuration("WEBHOOK_REPLAY_WINDOW", 5*time.Minute)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		if err := verifySignature(r.Header, r.URL.Query(), body, secrets, window, time.Now()); err != nil {
+			log.Printf("Webhook rejected from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+func verifySignature(header http.Header, query url.Values, body []byte, secrets [][]byte, window time.Duration, now time.Time) error {
+	signature := strings.TrimPrefix(header.Get(SignatureHeader), "sha256=")
+	timestamp := header.Get(TimestampHeader)
+	if signature == "" || timestamp == "" {
+		return errors.New("request is not signed")
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > window || age < -window {
+		return errors.New("timestamp outside the replay window")
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid signature")
+	}
+
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(timestamp + "." + header.Get(DeliveryIDHeader) + "." + canonicalQuery(query) + "."))
+		mac.Write(body)
+		if hmac.Equal(got, mac.Sum(nil)) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// canonicalQuery writes query parameters as sorted, unescaped "key=value"
+// pairs joined by "&", which the Apps Script can build the same way.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, key+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
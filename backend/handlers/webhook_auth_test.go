@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sign signs a request the way the Apps Script does.
+func sign(secret string, at time.Time, deliveryID, query string, body []byte) http.Header {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + deliveryID + "." + query + "."))
+	mac.Write(body)
+
+	header := http.Header{}
+	header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	header.Set(TimestampHeader, timestamp)
+	header.Set(DeliveryIDHeader, deliveryID)
+	return header
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	body := []byte(`[{"uuid":"u-101","field":"Price","value":12.5}]`)
+	atomic := url.Values{"atomic": {"true"}}
+
+	tests := []struct {
+		name    string
+		secrets []string
+		header  http.Header
+		query   url.Values
+		body    []byte
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now, "d-1", "", body),
+		},
+		{
+			name:    "valid with query",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now, "d-1", "atomic=true", body),
+			query:   atomic,
+		},
+		{
+			name:    "signed with the old secret during rotation",
+			secrets: []string{"new", "old"},
+			header:  sign("old", now, "d-1", "", body),
+		},
+		{
+			name:    "signed with the new secret during rotation",
+			secrets: []string{"new", "old"},
+			header:  sign("new", now, "d-1", "", body),
+		},
+		{
+			name:    "old secret removed after rotation",
+			secrets: []string{"new"},
+			header:  sign("old", now, "d-1", "", body),
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			secrets: []string{"s3cret"},
+			header:  sign("guess", now, "d-1", "", body),
+			wantErr: true,
+		},
+		{
+			name:    "body changed",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now, "d-1", "", body),
+			body:    []byte(`[{"uuid":"u-101","field":"Price","value":0}]`),
+			wantErr: true,
+		},
+		{
+			name:    "delivery ID changed",
+			secrets: []string{"s3cret"},
+			header: func() http.Header {
+				h := sign("s3cret", now, "d-1", "", body)
+				h.Set(DeliveryIDHeader, "d-2")
+				return h
+			}(),
+			wantErr: true,
+		},
+		{
+			name:    "atomic flag added",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now, "d-1", "", body),
+			query:   atomic,
+			wantErr: true,
+		},
+		{
+			name:    "not hex",
+			secrets: []string{"s3cret"},
+			header: func() http.Header {
+				h := sign("s3cret", now, "d-1", "", body)
+				h.Set(SignatureHeader, "sha256=zz")
+				return h
+			}(),
+			wantErr: true,
+		},
+		{
+			name:    "unsigned",
+			secrets: []string{"s3cret"},
+			header:  http.Header{},
+			wantErr: true,
+		},
+		{
+			name:    "timestamp too old",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now.Add(-6*time.Minute), "d-1", "", body),
+			wantErr: true,
+		},
+		{
+			name:    "timestamp too far ahead",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now.Add(6*time.Minute), "d-1", "", body),
+			wantErr: true,
+		},
+		{
+			name:    "clock skew within the window",
+			secrets: []string{"s3cret"},
+			header:  sign("s3cret", now.Add(-4*time.Minute), "d-1", "", body),
+		},
+		{
+			name:    "no secrets configured",
+			header:  sign("", now, "d-1", "", body),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var secrets [][]byte
+			for _, s := range tt.secrets {
+				secrets = append(secrets, []byte(s))
+			}
+			b := tt.body
+			if b == nil {
+				b = body
+			}
+
+			err := verifySignature(tt.header, tt.query, b, secrets, 5*time.Minute, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifySignature() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	query, _ := url.ParseQuery("b=2&atomic=true&a=x+y&a=1")
+	if got, want := canonicalQuery(query), "a=1&a=x y&atomic=true&b=2"; got != want {
+		t.Fatalf("canonicalQuery() = %q, want %q", got, want)
+	}
+}
@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
 	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
 )
 
 // --- MISSING STRUCT ADDED BACK HERE ---
@@ -17,48 +20,110 @@ type SheetUpdatePayload struct {
 	Field     string      `json:"field"`
 	Value     interface{} `json:"value"`
 	UserEmail string      `json:"user_email"`
+	// Version is the row's version as shown in the sheet. Edits without it
+	// are applied unchecked.
+	Version *int64 `json:"version,omitempty"`
+	// Action is ActionUpdate (the default) or ActionDelete, which moves the
+	// row to the trash and needs no Field or Value.
+	Action string `json:"action,omitempty"`
 }
 
-// Reusable value parser
-func parseValue(field string, val interface{}) (string, interface{}) {
-	var dbValue interface{}
-	var dbField string
-
-	switch field {
-	case "Product Name":
-		dbField = "product_name"
-		dbValue = fmt.Sprintf("%v", val)
-	case "Quantity":
-		dbField = "quantity"
-		if v, ok := val.(float64); ok {
-			dbValue = int(v)
-		} else {
-			i, _ := strconv.Atoi(fmt.Sprintf("%v", val))
-			dbValue = i
-		}
-	case "Price":
-		dbField = "price"
-		if v, ok := val.(float64); ok {
-			dbValue = v
-		} else {
-			f, _ := strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
-			dbValue = f
-		}
-	case "Discount":
-		dbField = "discount"
-		if v, ok := val.(bool); ok {
-			dbValue = v
-		} else {
-			strVal := fmt.Sprintf("%v", val)
-			dbValue = (strVal == "true" || strVal == "TRUE")
-		}
-	default:
-		return "", nil // Signal to skip
+// Payload actions.
+const (
+	ActionUpdate = database.EditUpdate
+	ActionDelete = database.EditDelete
+)
+
+// Outcomes of a single payload item.
+const (
+	ItemApplied    = database.EditApplied
+	ItemUnchanged  = "unchanged"             // a read-only column sent as it is
+	ItemInvalid    = "invalid"               // bad uuid, field or value
+	ItemConflict   = database.EditConflict   // rejected by CONFLICT_POLICY
+	ItemFailed     = database.EditFailed     // database error
+	ItemRolledBack = database.EditRolledBack // valid, but an atomic batch failed
+)
+
+// ItemResult reports what happened to the payload item at Index. Value is
+// the value as stored in the database.
+type ItemResult struct {
+	Index  int         `json:"index"`
+	UUID   string      `json:"uuid"`
+	Action string      `json:"action"`
+	Field  string      `json:"field,omitempty"`
+	Status string      `json:"status"`
+	Value  interface{} `json:"value,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// SheetWebhookResponse tells the Apps Script what happened to a batch.
+// Versions holds the new version of every row in the batch, the sheet has
+// to keep its Version column current itself as its own edits are not synced
+// back to it.
+type SheetWebhookResponse struct {
+	Processed int                 `json:"processed"`
+	Failed    int                 `json:"failed"`
+	Atomic    bool                `json:"atomic"`
+	Results   []ItemResult        `json:"results"`
+	Conflicts []database.Conflict `json:"conflicts"`
+	Versions  map[string]int64    `json:"versions"`
+}
+
+// errReadOnly is returned by parseValue for columns the sheet can't change.
+var errReadOnly = errors.New("read-only")
+
+// Reusable value parser. Field is the sheet header of the edited column.
+func parseValue(field string, val interface{}) (string, interface{}, error) {
+	mapping, ok := config.MappingForTable("product")
+	if !ok {
+		return "", nil, fmt.Errorf("product table is not synced")
+	}
+
+	col, ok := mapping.ColumnByHeader(field)
+	if !ok {
+		return "", nil, fmt.Errorf("unknown column %q", field)
+	}
+	if !mapping.Writable(col.Name) {
+		return "", nil, fmt.Errorf("column %q is %w", field, errReadOnly)
 	}
-	return dbField, dbValue
+
+	dbValue, err := col.Coerce(val)
+	if err != nil {
+		return "", nil, err
+	}
+	return col.Name, dbValue, nil
+}
+
+// readOnlyUnchanged reports whether an edit of a read-only column sends what
+// the cell already shows: the row's own UUID, a blank cell of a row that is
+// not stored yet or the stored value. Scripts that send every cell of a new
+// row do that, and it is no reason to reject the row.
+func readOnlyUnchanged(p SheetUpdatePayload) bool {
+	mapping, _ := config.MappingForTable("product")
+	col, _ := mapping.ColumnByHeader(p.Field)
+
+	sent := ""
+	if p.Value != nil {
+		sent = fmt.Sprintf("%v", p.Value)
+	}
+	if col.Name == mapping.PrimaryKey {
+		return sent == p.UUID
+	}
+
+	current, err := Products.Get(p.UUID)
+	if errors.Is(err, database.ErrProductNotFound) {
+		return sent == ""
+	}
+	if err != nil {
+		return false
+	}
+	return sent == fmt.Sprintf("%v", col.SheetValue(current.Row()[col.Name]))
 }
 
-func SheetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+// SheetWebhookHandler applies a batch of sheet edits and row deletions. The
+// outcome of each is also sent to the sync worker on feedback, to be shown
+// on the edited cells.
+func SheetWebhookHandler(w http.ResponseWriter, r *http.Request, feedback chan<- gsheets.Feedback) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -96,39 +161,203 @@ func SheetWebhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Received Update with %d changes", len(payloads))
 
-	// ... (The rest of your transaction logic remains exactly the same) ...
+	// With ?atomic=true the batch is applied only if every item is.
+	atomic := r.URL.Query().Get("atomic") == "true"
 
-	successCount := 0
-	tx, err := database.DB.Begin()
-	if err != nil {
+	resp := SheetWebhookResponse{Atomic: atomic, Results: make([]ItemResult, len(payloads))}
+
+	// Valid items become edits, invalid ones are answered right away.
+	var edits []database.ProductEdit
+	var editIndex []int
+	invalid := 0
+
+	for i, p := range payloads {
+		if p.Action == "" {
+			p.Action = ActionUpdate
+		}
+		result := ItemResult{Index: i, UUID: p.UUID, Action: p.Action, Field: p.Field, Status: ItemInvalid}
+
+		var dbField string
+		var dbValue interface{}
+		var err error
+		switch {
+		case p.Action != ActionUpdate && p.Action != ActionDelete:
+			err = fmt.Errorf("unknown action %q", p.Action)
+		case p.UUID == "" || (p.Field == "" && p.Action == ActionUpdate):
+			err = errors.New("uuid and field are required")
+		case p.Action == ActionUpdate:
+			dbField, dbValue, err = parseValue(p.Field, p.Value)
+		}
+		if errors.Is(err, errReadOnly) && readOnlyUnchanged(p) {
+			result.Status = ItemUnchanged
+			resp.Results[i] = result
+			continue
+		}
+		if err != nil {
+			result.Error = err.Error()
+			resp.Results[i] = result
+			invalid++
+			continue
+		}
+
+		result.Value = dbValue
+		resp.Results[i] = result
+		edits = append(edits, database.ProductEdit{
+			UUID:        p.UUID,
+			Action:      p.Action,
+			Field:       dbField,
+			Value:       dbValue,
+			Author:      p.UserEmail,
+			BaseVersion: p.Version,
+		})
+		editIndex = append(editIndex, i)
+	}
+
+	// merge fills in what happened to the edits.
+	merge := func(bulk database.BulkResult) {
+		for j, r := range bulk.Results {
+			res := &resp.Results[editIndex[j]]
+			res.Status, res.Error = r.Status, r.Error
+		}
+		resp.Conflicts = bulk.Conflicts
+		resp.Versions = bulk.Versions
+
+		resp.Processed, resp.Failed = 0, 0
+		for _, res := range resp.Results {
+			if res.Status == ItemApplied || res.Status == ItemUnchanged {
+				resp.Processed++
+			} else {
+				resp.Failed++
+			}
+		}
+	}
+
+	var response []byte
+	deliveryID := r.Header.Get(DeliveryIDHeader)
+
+	bulk, err := Products.BulkUpsert(edits, database.BulkOptions{
+		Origin: database.OriginSheet,
+		Policy: database.ConflictPolicy(),
+		Atomic: atomic,
+		// An atomic batch with invalid items is not applied, its versions
+		// are still checked so conflicts get recorded.
+		DryRun: atomic && invalid > 0,
+
+		// A redelivered batch gets the answer the first delivery got.
+		DeliveryID:        deliveryID,
+		DeliveryRetention: DeliveryRetention(),
+		Respond: func(bulk database.BulkResult) (database.Delivery, error) {
+			merge(bulk)
+			var err error
+			response, err = json.Marshal(resp)
+			return database.Delivery{StatusCode: http.StatusOK, Response: response}, err
+		},
+	})
+	switch {
+	case errors.Is(err, database.ErrDeliveryInProgress):
+		http.Error(w, "Delivery is already being processed", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Webhook Error: %v", err)
 		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
+	case bulk.Replay != nil:
+		log.Printf("Delivery %s was already processed, replaying its response", deliveryID)
+		replayDelivery(w, bulk.Replay)
+		return
+	case bulk.RolledBack:
+		merge(bulk)
+		rejectAtomicBatch(w, resp)
+		sendFeedback(feedback, payloads, resp.Results)
+		return
 	}
-	defer tx.Rollback()
 
-	for _, p := range payloads {
-		if p.UUID == "" || p.Field == "" {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+
+	sendFeedback(feedback, payloads, resp.Results)
+}
+
+// sendFeedback tells the sync worker about the outcome of every item. Each
+// edited cell gets an annotation: the reason for edits that were not saved,
+// a note for values stored differently from how they were typed (e.g.
+// "1,299.00" as 1299) and a reset for the rest, which clears what an earlier
+// rejected edit of the cell left behind, if anything. Read-only cells sent
+// as they are were not edited and get none. Deleted rows are reported so the
+// worker stops relying on their position.
+func sendFeedback(feedback chan<- gsheets.Feedback, payloads []SheetUpdatePayload, results []ItemResult) {
+	mapping, ok := config.MappingForTable("product")
+	if feedback == nil || !ok {
+		return
+	}
+
+	var f gsheets.Feedback
+	for _, res := range results {
+		if res.Action == ActionDelete {
+			if res.Status == ItemApplied {
+				if f.Removed == nil {
+					f.Removed = map[string][]string{}
+				}
+				f.Removed[mapping.Table] = append(f.Removed[mapping.Table], res.UUID)
+			}
 			continue
 		}
 
-		dbField, dbValue := parseValue(p.Field, p.Value)
-		if dbField == "" {
+		col, ok := mapping.ColumnByHeader(res.Field)
+		if res.UUID == "" || !ok || res.Status == ItemUnchanged {
 			continue
 		}
 
-		if err := database.TxUpsertProductField(tx, p.UUID, dbField, dbValue, p.UserEmail); err != nil {
-			log.Printf("Batch item failed (%s): %v", p.UUID, err)
-		} else {
-			successCount++
+		a := gsheets.CellAnnotation{Table: mapping.Table, RowID: res.UUID, Column: col.Name, Kind: gsheets.AnnotateClear}
+		switch res.Status {
+		case ItemApplied:
+			// A blank cell saved as its column's default was not typed.
+			typed := payloads[res.Index].Value
+			stored := fmt.Sprintf("%v", col.SheetValue(res.Value))
+			if typed != nil && typed != "" && fmt.Sprintf("%v", typed) != stored {
+				a.Kind, a.Note = gsheets.AnnotateAdjusted, "Saved as "+stored
+			}
+		case ItemRolledBack:
+			a.Kind, a.Note = gsheets.AnnotateError, "Not saved: another edit in the same batch was invalid"
+		default:
+			a.Kind, a.Note = gsheets.AnnotateError, "Not saved: "+res.Error
 		}
+		f.Annotations = append(f.Annotations, a)
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Transaction Commit Failed: %v", err)
-		http.Error(w, "Transaction failed", http.StatusInternalServerError)
+	if len(f.Annotations) == 0 && len(f.Removed) == 0 {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Processed %d updates", successCount)))
+	select {
+	case feedback <- f:
+	default:
+		log.Printf("Feedback queue full, dropping %d cell annotations", len(f.Annotations))
+	}
+}
+
+// rejectAtomicBatch answers an atomic batch that was rolled back with 422.
+func rejectAtomicBatch(w http.ResponseWriter, resp SheetWebhookResponse) {
+	log.Printf("Atomic batch rolled back, %d of %d items failed", resp.Failed, len(resp.Results))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeliveryIDHeader carries the idempotency key of a webhook batch. It has to
+// stay the same when a batch is sent again.
+const DeliveryIDHeader = "X-Delivery-ID"
+
+// DeliveryRetention is how long processed delivery IDs are remembered
+// (WEBHOOK_DELIVERY_RETENTION, default 24h).
+func DeliveryRetention() time.Duration {
+	return config.EnvDuration("WEBHOOK_DELIVERY_RETENTION", 24*time.Hour)
+}
+
+func replayDelivery(w http.ResponseWriter, d *database.Delivery) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Delivery-Replayed", "true")
+	w.WriteHeader(d.StatusCode)
+	w.Write(d.Response)
 }
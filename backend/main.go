@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
 	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
 	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
 	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
 	"github.com/Sultan-Ubiquitous/sheets-to-db/handlers"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/worker"
 	"github.com/joho/godotenv"
 )
 
@@ -36,16 +41,9 @@ func main() {
 
 	log.Println("Successfully connected to MySQL database!")
 
-	binlogFile, binlogPos, err := database.GetMasterStatus()
-	if err != nil {
-		log.Fatalf("Error getting master status: %v", err)
+	if err := database.Migrate(); err != nil {
+		log.Fatalf("Fatal error: Could not update the database schema (see \"Upgrading\" in the README): %v", err)
 	}
-	log.Printf("Snapshot taken. Resume CDC from %s:%d", binlogFile, binlogPos)
-
-	authReadySignal := make(chan struct{}, 1)
-	syncChannel := make(chan cdc.SyncEvent, 100)
-
-	go cdc.StartListener(syncChannel, binlogFile, binlogPos)
 
 	spreadsheetID := os.Getenv("SPREADSHEET_ID")
 
@@ -54,70 +52,87 @@ func main() {
 		log.Fatal("CRITICAL ERROR: SPREADSHEET_ID is empty! Check your .env file.")
 	}
 
-	go func() {
-		log.Println("Starting Sheet Sync Worker...")
-
-		sm, err := gsheets.NewSheetManager(spreadsheetID)
-
-		if err != nil {
-			log.Println("Worker STALLED. Waiting for login...")
-			<-authReadySignal
-			log.Println("Resuming...")
-			sm, _ = gsheets.NewSheetManager(spreadsheetID)
-		}
-
-		if sm != nil {
-			log.Println("Performing Initial Full Sync...")
-			products, err := database.GetAllProducts()
-			if err != nil {
-				log.Printf("Error fetching initial products: %v", err)
-			} else {
-				if err := sm.ClearAndOverwrite(products); err != nil {
-					log.Printf("Error performing initial sheet overwrite: %v", err)
-				}
-			}
+	if err := config.LoadTableMappings(); err != nil {
+		log.Fatalf("Error loading sync config: %v", err)
+	}
+	for _, m := range config.TableMappings {
+		if err := database.SaveTableMapping(spreadsheetID, m); err != nil {
+			log.Fatalf("Error registering table mapping: %v", err)
 		}
+	}
+	if err := database.RemoveTableMappings(spreadsheetID, config.TableMappings); err != nil {
+		log.Fatalf("Error registering table mapping: %v", err)
+	}
 
-		log.Println("Sheet Manager Running via Event Loop")
+	mappings, err := database.GetTableMappings(spreadsheetID)
+	if err != nil {
+		log.Fatalf("Error loading table mappings: %v", err)
+	}
+	if err := config.ValidateMappings(mappings); err != nil {
+		log.Fatalf("Invalid table mappings in sheet_mappings: %v", err)
+	}
+	config.TableMappings = mappings
 
-		for {
-			select {
-			case <-authReadySignal:
-				log.Println("Hot Reload: Refreshing Sheet Manager with new token...")
-				newSm, err := gsheets.NewSheetManager(spreadsheetID)
-				if err == nil {
-					sm = newSm
-					log.Println("Sheet Manager refreshed successfully!")
+	products := database.MySQLProducts{}
+	handlers.Products = products
 
-					products, _ := database.GetAllProducts()
-					sm.ClearAndOverwrite(products)
-				} else {
-					log.Printf("Failed to refresh manager: %v", err)
-				}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore(products, os.Args[2:])
+		return
+	}
 
-			case event := <-syncChannel:
-				if sm == nil {
-					log.Println("Skipping event: Sheet Manager not ready")
-					continue
-				}
+	log.Printf("Syncing %d table(s) to spreadsheet %s", len(mappings), spreadsheetID)
 
-				log.Printf("Processing Event: %s %s", event.Action, event.RowID)
+	start, needsSnapshot, err := cdc.ResolveStart()
+	if err != nil {
+		log.Fatalf("Error resolving CDC start position: %v", err)
+	}
+	if needsSnapshot {
+		log.Printf("Snapshot taken. Resume CDC from %s", start)
+	} else {
+		log.Printf("Found checkpoint. Resume CDC from %s", start)
+	}
 
-				var err error
+	authReadySignal := make(chan struct{}, 1)
+	outboxSignal := make(chan struct{}, 1)
+	driftSignal := make(chan struct{}, 1)
+	feedbackChannel := make(chan gsheets.Feedback, 100)
+	syncChannel := make(chan cdc.SyncEvent, 100)
 
-				if event.Action == "delete" {
-					err = sm.DeleteRow(event.RowID)
-				} else {
-					err = sm.SyncToSheet(event.RowID, event.Data)
-				}
+	go cdc.StartListener(syncChannel, start, mappings)
 
-				if err != nil {
-					log.Printf("Error syncing (%s): %v", event.Action, err)
-				}
+	go func() {
+		for range time.Tick(time.Hour) {
+			if n, err := database.PurgeDeliveries(handlers.DeliveryRetention()); err != nil {
+				log.Printf("Error purging webhook deliveries: %v", err)
+			} else if n > 0 {
+				log.Printf("Purged %d expired webhook deliveries", n)
 			}
 		}
 	}()
 
+	driftHeal := os.Getenv("DRIFT_HEAL")
+	if driftHeal != "" && driftHeal != healDB && driftHeal != healSheet && driftHeal != healNewest {
+		log.Printf("Unknown DRIFT_HEAL %q, drift will only be reported", driftHeal)
+		driftHeal = ""
+	}
+
+	w := worker.New(syncChannel, feedbackChannel)
+	w.AuthReady = authReadySignal
+	w.RetryOutbox = outboxSignal
+	w.CheckDrift = driftSignal
+	w.Connect = func() (*gsheets.SheetManager, error) {
+		return gsheets.NewSheetManager(spreadsheetID, mappings)
+	}
+	w.Snapshot = needsSnapshot
+	w.FullSync = func(sm *gsheets.SheetManager) { fullSync(sm, products, mappings) }
+	w.DrainOutbox = drainOutbox
+	w.ReplayOutbox = func(sm *gsheets.SheetManager) { replayOutbox(sm) }
+	w.Drift = func(sm *gsheets.SheetManager) { checkDrift(sm, products, mappings, driftHeal) }
+	w.Queue = queueFailed
+	w.SaveCheckpoint = cdc.SaveCheckpoint
+	go w.Run(context.Background())
+
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
 
@@ -137,7 +152,25 @@ func main() {
 	})
 
 	http.HandleFunc("/api/products/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPut {
+		if r.URL.Path == "/api/products/trash" {
+			if r.Method == http.MethodGet {
+				handlers.ListTrashHandler(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/history") {
+			if r.Method == http.MethodGet {
+				handlers.ProductHistoryHandler(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if strings.HasSuffix(r.URL.Path, "/restore") {
+			if r.Method == http.MethodPost {
+				handlers.RestoreProductHandler(w, r)
+			} else {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		} else if r.Method == http.MethodPut {
 			handlers.UpdateProductHandler(w, r)
 		} else if r.Method == http.MethodDelete {
 			handlers.DeleteProductHandler(w, r)
@@ -146,7 +179,59 @@ func main() {
 		}
 	})
 
-	http.HandleFunc("/api/webhook/sheets", handlers.SheetWebhookHandler)
+	http.HandleFunc("/api/webhook/sheets", handlers.SignedWebhook(func(w http.ResponseWriter, r *http.Request) {
+		handlers.SheetWebhookHandler(w, r, feedbackChannel)
+	}))
+
+	http.HandleFunc("/api/admin/outbox", handlers.AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handlers.ListOutboxHandler(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/admin/outbox/", handlers.AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			handlers.RetryOutboxHandler(w, r, outboxSignal)
+		} else if r.Method == http.MethodDelete {
+			handlers.DiscardOutboxHandler(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/admin/drift", handlers.AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handlers.ListDriftHandler(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/admin/drift/check", handlers.AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			handlers.CheckDriftHandler(w, r, driftSignal)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/admin/restore", handlers.AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			handlers.RestoreHandler(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	http.HandleFunc("/api/admin/conflicts", handlers.AdminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			handlers.ListConflictsHandler(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
 
 	port := ":8080"
 	log.Printf("Server starting on http://localhost%s", port)
@@ -154,3 +239,364 @@ func main() {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
+
+// fullSync brings every mapped tab in line with its table, rewriting only the
+// rows that differ.
+func fullSync(sm *gsheets.SheetManager, products database.ProductRepository, mappings []config.TableMapping) {
+	for _, m := range mappings {
+		started := time.Now()
+		rows, err := tableRows(products, m)
+		if err != nil {
+			log.Printf("Error fetching rows of %s: %v", m.Table, err)
+			continue
+		}
+		rows = deleteMissingRows(sm, m, rows)
+
+		if _, err := sm.Reconcile(m.Table, rows); err != nil {
+			log.Printf("Error reconciling %s: %v", m.Tab, err)
+			continue
+		}
+		if err := database.MarkReconciled(sm.SpreadsheetID, m.Table, started); err != nil {
+			log.Printf("Error recording reconcile of %s: %v", m.Table, err)
+		}
+	}
+}
+
+// tableRows returns the rows of a mapped table that belong in its tab.
+// Products come from the product repository, other tables, and a product
+// mapping with columns the Product model doesn't have, are read as is.
+func tableRows(products database.ProductRepository, m config.TableMapping) ([]map[string]interface{}, error) {
+	if m.Table != "product" {
+		return database.GetTableRows(m)
+	}
+	known := database.Product{}.Row()
+	for _, name := range m.ColumnNames() {
+		if _, ok := known[name]; !ok {
+			return database.GetTableRows(m)
+		}
+	}
+
+	list, err := products.List(false)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]interface{}, len(list))
+	for i, p := range list {
+		rows[i] = p.Row()
+	}
+	return rows, nil
+}
+
+// deleteMissingRows treats rows that are gone from the tab as deleted in the
+// sheet when SHEET_MISSING_ROWS=delete, deletes them from the table and
+// returns the remaining rows. Only rows last changed before the previous
+// reconcile are deleted: those were in the sheet then, anything newer may
+// just not have reached it yet.
+func deleteMissingRows(sm *gsheets.SheetManager, m config.TableMapping, rows []map[string]interface{}) []map[string]interface{} {
+	if os.Getenv("SHEET_MISSING_ROWS") != "delete" {
+		return rows
+	}
+
+	var updatedAt string
+	for _, c := range m.Columns {
+		if c.Type == config.TypeTimestamp {
+			updatedAt = c.Name
+			break
+		}
+	}
+	since, err := database.LastReconciled(sm.SpreadsheetID, m.Table)
+	if err != nil || since.IsZero() || updatedAt == "" {
+		return rows
+	}
+
+	current, _, err := sm.ReadRows(m.Table)
+	if err != nil {
+		log.Printf("Error reading %s for removed rows: %v", m.Tab, err)
+		return rows
+	}
+	lagging, err := database.OutboxRowIDs(m.Table)
+	if err != nil {
+		log.Printf("Error reading outbox: %v", err)
+		return rows
+	}
+
+	var kept []map[string]interface{}
+	var missing []string
+	for _, row := range rows {
+		id := fmt.Sprintf("%v", row[m.PrimaryKey])
+		if _, ok := current[id]; ok || lagging[id] {
+			kept = append(kept, row)
+			continue
+		}
+		changed, err := time.Parse(database.TimeLayout, fmt.Sprintf("%v", row[updatedAt]))
+		if err != nil || !changed.Before(since) {
+			kept = append(kept, row)
+			continue
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return rows
+	}
+
+	if err := database.DeleteRemovedRows(m, missing); err != nil {
+		log.Printf("Error deleting rows removed from %s: %v", m.Tab, err)
+		return rows
+	}
+	log.Printf("Deleted %d %s rows that were removed from %s: %v", len(missing), m.Table, m.Tab, missing)
+	return kept
+}
+
+// queueFailed stores events that could not be written to the sheet in the
+// outbox. It returns the events that could not be stored either.
+func queueFailed(events []cdc.SyncEvent, cause error) []cdc.SyncEvent {
+	var unsaved []cdc.SyncEvent
+	for _, e := range events {
+		if err := database.EnqueueOutbox(e.Table, e.RowID, e.Action, e.Data, cause.Error()); err != nil {
+			log.Printf("Error queueing %s %s: %v", e.Table, e.RowID, err)
+			unsaved = append(unsaved, e)
+		}
+	}
+	return unsaved
+}
+
+// drainOutbox replays every pending outbox entry right away, used once the
+// Sheet Manager becomes available.
+func drainOutbox(sm *gsheets.SheetManager) {
+	if _, err := database.RetryAllOutbox(database.OutboxPending); err != nil {
+		log.Printf("Error scheduling outbox replay: %v", err)
+		return
+	}
+	for replayOutbox(sm) == outboxReplayLimit {
+	}
+}
+
+const outboxReplayLimit = 100
+
+// replayOutbox retries the outbox entries that are due and returns how many
+// were synced. Rows are re-read from the database rather than taken from the
+// stored payload, so a late retry can't overwrite newer data that was synced
+// in the meantime.
+func replayOutbox(sm *gsheets.SheetManager) int {
+	entries, err := database.DueOutboxEntries(outboxReplayLimit)
+	if err != nil {
+		log.Printf("Error reading outbox: %v", err)
+		return 0
+	}
+	if len(entries) == 0 {
+		return 0
+	}
+
+	var events []cdc.SyncEvent
+	var ids []int64
+	for _, e := range entries {
+		m, ok := config.MappingForTable(e.Table)
+		if !ok {
+			// The table was unmapped since, there is no tab to write to.
+			log.Printf("Outbox entry %d is for unmapped table %s, marking it failed", e.ID, e.Table)
+			if err := database.FailOutboxEntry(e.ID, "table "+e.Table+" is not mapped to a tab"); err != nil {
+				log.Printf("Error marking outbox entry %d failed: %v", e.ID, err)
+			}
+			continue
+		}
+
+		row, err := database.GetTableRow(m, e.RowID)
+		if err != nil {
+			rescheduleOutbox(e.ID, fmt.Errorf("failed to read %s %s: %v", e.Table, e.RowID, err))
+			continue
+		}
+
+		event := cdc.SyncEvent{Source: "OUTBOX", Table: e.Table, RowID: e.RowID, Action: "delete"}
+		if row != nil {
+			event.Action = "update"
+			event.Data = row
+		}
+		ids = append(ids, e.ID)
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return 0
+	}
+
+	log.Printf("Retrying %d outbox entries", len(events))
+	if err := sm.ApplyBatch(events); err == nil {
+		if err := database.DeleteOutboxEntries(ids); err != nil {
+			log.Printf("Error clearing outbox entries: %v", err)
+			return 0
+		}
+		return len(ids)
+	} else if len(events) == 1 {
+		rescheduleOutbox(ids[0], err)
+		return 0
+	}
+
+	// Go one by one so a single bad row doesn't hold back the rest.
+	var synced []int64
+	for i, event := range events {
+		if err := sm.ApplyBatch([]cdc.SyncEvent{event}); err != nil {
+			rescheduleOutbox(ids[i], err)
+			continue
+		}
+		synced = append(synced, ids[i])
+	}
+	if err := database.DeleteOutboxEntries(synced); err != nil {
+		log.Printf("Error clearing outbox entries: %v", err)
+		return 0
+	}
+	return len(synced)
+}
+
+func rescheduleOutbox(id int64, cause error) {
+	log.Printf("Outbox entry %d failed again: %v", id, cause)
+	maxAttempts := config.EnvInt("OUTBOX_MAX_ATTEMPTS", 10)
+	if err := database.RescheduleOutboxEntries([]int64{id}, cause.Error(), maxAttempts); err != nil {
+		log.Printf("Error rescheduling outbox entry %d: %v", id, err)
+	}
+}
+
+// Auto-heal directions for the drift check (DRIFT_HEAL).
+const (
+	healDB     = "db"
+	healSheet  = "sheet"
+	healNewest = "newest"
+)
+
+// checkDrift compares every mapped tab with its table and records what
+// differs in sync_drift. When heal is set the winning side is copied over the
+// other. Rows still waiting in the outbox are skipped, they are known to be
+// behind and will be fixed by the retry.
+func checkDrift(sm *gsheets.SheetManager, products database.ProductRepository, mappings []config.TableMapping, heal string) {
+	for _, m := range mappings {
+		rows, err := tableRows(products, m)
+		if err != nil {
+			log.Printf("Drift check: error fetching rows of %s: %v", m.Table, err)
+			continue
+		}
+		rows = deleteMissingRows(sm, m, rows)
+
+		drifts, err := sm.Diff(m.Table, rows)
+		if err != nil {
+			log.Printf("Drift check: error reading %s: %v", m.Tab, err)
+			continue
+		}
+		lagging, err := database.OutboxRowIDs(m.Table)
+		if err != nil {
+			log.Printf("Drift check: error reading outbox: %v", err)
+			continue
+		}
+
+		var records []database.Discrepancy
+		var events []cdc.SyncEvent
+
+		for _, d := range drifts {
+			if lagging[d.RowID] {
+				continue
+			}
+
+			resolution := ""
+			switch winner, fields := driftWinner(m, d, heal); winner {
+			case healDB:
+				resolution = healDB
+				event := cdc.SyncEvent{Source: "DRIFT", Table: m.Table, RowID: d.RowID, Action: "update", Data: d.DB}
+				if d.DB == nil {
+					event.Action = "delete"
+				}
+				events = append(events, event)
+			case healSheet:
+				// Not marked as a sheet transaction on purpose, the CDC
+				// echo refreshes the row's read-only columns in the sheet.
+				if err := database.UpdateTableRow(m, d.RowID, fields, database.OriginDrift); err != nil {
+					log.Printf("Drift check: error healing %s %s from the sheet: %v", m.Table, d.RowID, err)
+				} else {
+					resolution = healSheet
+				}
+			}
+
+			for _, rec := range d.Discrepancies(m.Table) {
+				rec.Resolution = resolution
+				records = append(records, rec)
+			}
+		}
+
+		if err := sm.ApplyBatch(events); err != nil {
+			log.Printf("Drift check: error healing %s from the database: %v", m.Tab, err)
+			for i := range records {
+				if records[i].Resolution == healDB {
+					records[i].Resolution = ""
+				}
+			}
+		}
+
+		if err := database.RecordDiscrepancies(records); err != nil {
+			log.Printf("Drift check: %v", err)
+		}
+		log.Printf("Drift check of %s: %d rows differ", m.Tab, len(drifts))
+	}
+}
+
+// driftWinner decides which side of a drifted row is kept, or "" to only
+// report it. For the sheet it also returns the coerced values to write to the
+// database. Rows are never created or deleted in the database from the
+// sheet's side: with "sheet" rows missing on one side are only reported, with
+// "newest" rows missing in the sheet are added to it and rows only in the
+// sheet are reported.
+func driftWinner(m config.TableMapping, d gsheets.RowDrift, heal string) (string, map[string]interface{}) {
+	switch heal {
+	case healDB:
+		return healDB, nil
+	case healSheet, healNewest:
+		if d.Kind != database.DriftMismatch {
+			if heal == healNewest && d.DB != nil {
+				return healDB, nil
+			}
+			return "", nil
+		}
+		if heal == healNewest && !sheetIsNewer(m, d) {
+			return healDB, nil
+		}
+
+		fields := map[string]interface{}{}
+		for _, name := range d.Fields {
+			c, ok := m.Column(name)
+			if !ok || !m.Writable(name) {
+				continue
+			}
+			// A cell that isn't a valid value can't win.
+			val, err := c.Coerce(d.Sheet[name])
+			if err != nil {
+				return healDB, nil
+			}
+			fields[name] = val
+		}
+		// Only read-only columns differ, those can only come from the database.
+		if len(fields) == 0 {
+			return healDB, nil
+		}
+		return healSheet, fields
+	}
+	return "", nil
+}
+
+// sheetIsNewer compares the row's first timestamp column (updated_at) with
+// the sheet's copy of it. The sheet only ever gets that value from the
+// database, so it says when the row was last synced: if the database has not
+// changed since, the values that differ were edited in the sheet afterwards.
+// A row changed in the database since, or without a readable time in the
+// sheet, is newer in the database.
+func sheetIsNewer(m config.TableMapping, d gsheets.RowDrift) bool {
+	for _, c := range m.Columns {
+		if c.Type != config.TypeTimestamp {
+			continue
+		}
+		dbTime, err := time.Parse(database.TimeLayout, fmt.Sprintf("%v", d.DB[c.Name]))
+		if err != nil {
+			return false
+		}
+		sheetTime, err := time.Parse(database.TimeLayout, fmt.Sprintf("%v", d.Sheet[c.Name]))
+		if err != nil {
+			return false
+		}
+		return !dbTime.After(sheetTime)
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
+)
+
+var driftMapping = config.TableMapping{
+	Table:      "product",
+	PrimaryKey: "uuid",
+	Tab:        "Sheet1",
+	Columns: []config.Column{
+		{Name: "uuid", Header: "UUID", Type: config.TypeString, ReadOnly: true},
+		{Name: "price", Header: "Price", Type: config.TypeDecimal},
+		{Name: "updated_at", Header: "Last Updated", Type: config.TypeTimestamp, ReadOnly: true},
+	},
+}
+
+func TestDriftWinnerNewest(t *testing.T) {
+	mismatch := func(dbTime, sheetTime string) gsheets.RowDrift {
+		return gsheets.RowDrift{
+			RowID:  "u-101",
+			Kind:   database.DriftMismatch,
+			Fields: []string{"price"},
+			DB:     map[string]interface{}{"uuid": "u-101", "price": 9.99, "updated_at": dbTime},
+			Sheet:  map[string]interface{}{"uuid": "u-101", "price": 12.5, "updated_at": sheetTime},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		drift      gsheets.RowDrift
+		wantWinner string
+		wantFields map[string]interface{}
+	}{
+		{
+			name:       "edited in the sheet after the last sync",
+			drift:      mismatch("2026-01-01 09:00:00", "2026-01-01 09:00:00"),
+			wantWinner: healSheet,
+			wantFields: map[string]interface{}{"price": 12.5},
+		},
+		{
+			name:       "changed in the database after the last sync",
+			drift:      mismatch("2026-01-01 10:00:00", "2026-01-01 09:00:00"),
+			wantWinner: healDB,
+		},
+		{
+			name:       "no time in the sheet",
+			drift:      mismatch("2026-01-01 09:00:00", ""),
+			wantWinner: healDB,
+		},
+		{
+			name:       "missing in the sheet",
+			drift:      gsheets.RowDrift{RowID: "u-101", Kind: database.DriftMissingInSheet, DB: map[string]interface{}{"uuid": "u-101"}},
+			wantWinner: healDB,
+		},
+		{
+			name:       "missing in the database",
+			drift:      gsheets.RowDrift{RowID: "u-101", Kind: database.DriftMissingInDB, Sheet: map[string]interface{}{"uuid": "u-101"}},
+			wantWinner: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			winner, fields := driftWinner(driftMapping, tt.drift, healNewest)
+			if winner != tt.wantWinner || !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Fatalf("driftWinner() = %q, %v, want %q, %v", winner, fields, tt.wantWinner, tt.wantFields)
+			}
+		})
+	}
+}
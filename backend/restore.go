@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/database"
+)
+
+// runRestore implements "restore -at <time> [-uuid <uuid>] [-apply]", which
+// brings one product or the whole product table back to how it was at the
+// given time. Without -apply it only prints what would change. The sheet
+// catches up through the running sync.
+func runRestore(products database.ProductRepository, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	at := fs.String("at", "", `time to restore to, e.g. 2026-01-02T15:04:05Z or "2026-01-02 15:04:05" (UTC)`)
+	uuid := fs.String("uuid", "", "only restore this product")
+	apply := fs.Bool("apply", false, "write the changes, otherwise only print them")
+	fs.Parse(args)
+
+	t, err := database.ParseRestoreTime(*at)
+	if err != nil {
+		log.Fatalf("restore: %v", err)
+	}
+
+	mapping, ok := config.MappingForTable("product")
+	if !ok {
+		log.Fatal("restore: product table is not synced")
+	}
+
+	changes, err := database.PlanRestore(mapping, t, *uuid)
+	if err != nil {
+		log.Fatalf("restore: %v", err)
+	}
+	if len(changes) == 0 {
+		fmt.Println("Nothing to restore")
+		return
+	}
+	if !*apply {
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		fmt.Printf("Dry run, %d products would change. Run again with -apply to restore them.\n", len(changes))
+		return
+	}
+
+	database.ApplyRestore(products, mapping, changes)
+	restored := 0
+	for _, c := range changes {
+		fmt.Println(c)
+		if c.Status == database.EditApplied {
+			restored++
+		}
+	}
+	fmt.Printf("Restored %d of %d products\n", restored, len(changes))
+	if restored < len(changes) {
+		os.Exit(1)
+	}
+}
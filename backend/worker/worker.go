@@ -0,0 +1,235 @@
+// Package worker runs the loop that writes row changes read by the CDC
+// listener to the sheet.
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Sultan-Ubiquitous/sheets-to-db/cdc"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/config"
+	"github.com/Sultan-Ubiquitous/sheets-to-db/gsheets"
+)
+
+// Worker collects row changes into batches and writes them to the sheet.
+// Changes the sheet could not take are queued in the outbox, and the binlog
+// checkpoint is only saved once everything before it is in the sheet or the
+// outbox. Until Connect succeeds, i.e. until someone logged in, every change
+// goes to the outbox.
+//
+// What the worker does with the database is left to the funcs it is given,
+// so that it can run against something other than MySQL.
+type Worker struct {
+	// Events are the row changes and checkpoints from the CDC listener.
+	Events <-chan cdc.SyncEvent
+	// Feedback are the cells the webhook wants annotated.
+	Feedback <-chan gsheets.Feedback
+	// AuthReady is signalled after a login: Connect is called again and the
+	// sheet fully synced.
+	AuthReady <-chan struct{}
+	// RetryOutbox and CheckDrift ask for an outbox retry and a drift check
+	// right away.
+	RetryOutbox <-chan struct{}
+	CheckDrift  <-chan struct{}
+
+	// Connect makes the SheetManager.
+	Connect func() (*gsheets.SheetManager, error)
+	// Snapshot fully syncs the sheet on start, once connected.
+	Snapshot bool
+	// FullSync brings every tab in line with its table.
+	FullSync func(sm *gsheets.SheetManager)
+	// DrainOutbox replays every pending outbox entry, ReplayOutbox only the
+	// ones that are due.
+	DrainOutbox  func(sm *gsheets.SheetManager)
+	ReplayOutbox func(sm *gsheets.SheetManager)
+	// Drift compares the tabs with their tables.
+	Drift func(sm *gsheets.SheetManager)
+	// Queue stores events that could not be written to the sheet in the
+	// outbox. It returns the events that could not be stored either.
+	Queue func(events []cdc.SyncEvent, cause error) []cdc.SyncEvent
+	// SaveCheckpoint records how far the binlog was synced.
+	SaveCheckpoint func(checkpoint cdc.SyncEvent) error
+
+	// BatchWindow is how long changes are collected before being written
+	// together, BatchSize how many make it flush early.
+	BatchWindow time.Duration
+	BatchSize   int
+	// RetryInterval is how often the outbox is retried, and how long a batch
+	// the outbox could not store waits before being tried again.
+	RetryInterval time.Duration
+	DriftInterval time.Duration
+
+	sm         *gsheets.SheetManager
+	batch      *cdc.Batch
+	flushTimer <-chan time.Time
+	syncs      chan chan struct{}
+}
+
+// New returns a Worker for events and feedback with the batching and retry
+// settings taken from the environment. The funcs are left to the caller.
+func New(events <-chan cdc.SyncEvent, feedback <-chan gsheets.Feedback) *Worker {
+	return &Worker{
+		Events:        events,
+		Feedback:      feedback,
+		BatchWindow:   config.EnvDuration("SYNC_BATCH_WINDOW", 500*time.Millisecond),
+		BatchSize:     config.EnvInt("SYNC_BATCH_SIZE", 200),
+		RetryInterval: config.EnvDuration("OUTBOX_RETRY_INTERVAL", 30*time.Second),
+		DriftInterval: config.EnvDuration("DRIFT_CHECK_INTERVAL", time.Hour),
+		batch:         cdc.NewBatch(),
+		syncs:         make(chan chan struct{}),
+	}
+}
+
+// Run connects and runs the loop until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	log.Println("Starting Sheet Sync Worker...")
+
+	// Until someone logs in sm stays nil and events go to the outbox,
+	// AuthReady sets it in the loop below.
+	sm, err := w.Connect()
+	if err != nil {
+		log.Printf("Sheet Manager not ready, keeping changes in the outbox until login: %v", err)
+	} else {
+		w.sm = sm
+		if w.Snapshot {
+			log.Println("Performing Initial Full Sync...")
+			w.FullSync(sm)
+		}
+		w.DrainOutbox(sm)
+	}
+
+	log.Println("Sheet Manager Running via Event Loop")
+
+	retryTicker := time.NewTicker(w.RetryInterval)
+	defer retryTicker.Stop()
+	driftTicker := time.NewTicker(w.DriftInterval)
+	defer driftTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-w.AuthReady:
+			log.Println("Hot Reload: Refreshing Sheet Manager with new token...")
+			sm, err := w.Connect()
+			if err == nil {
+				w.sm = sm
+				log.Println("Sheet Manager refreshed successfully!")
+
+				w.FullSync(sm)
+				w.DrainOutbox(sm)
+			} else {
+				log.Printf("Failed to refresh manager: %v", err)
+			}
+
+		case event := <-w.Events:
+			w.add(event)
+
+		case <-w.flushTimer:
+			w.flush()
+
+		case <-retryTicker.C:
+			if w.sm != nil {
+				w.ReplayOutbox(w.sm)
+			}
+
+		case <-w.RetryOutbox:
+			if w.sm != nil {
+				w.ReplayOutbox(w.sm)
+			}
+
+		case <-driftTicker.C:
+			if w.sm != nil {
+				w.flush()
+				w.Drift(w.sm)
+			}
+
+		case <-w.CheckDrift:
+			if w.sm != nil {
+				w.flush()
+				w.Drift(w.sm)
+			}
+
+		case feedback := <-w.Feedback:
+			w.annotate(feedback)
+
+		case done := <-w.syncs:
+			// Take whatever was sent before the request, feedback first
+			// like a webhook's annotations come before the echo of its
+			// writes.
+			for len(w.Feedback) > 0 {
+				w.annotate(<-w.Feedback)
+			}
+			for len(w.Events) > 0 {
+				w.add(<-w.Events)
+			}
+			w.flush()
+			close(done)
+		}
+	}
+}
+
+// Sync has the running loop take the feedback and events already sent to
+// it and flush the batch, and returns once it did.
+func (w *Worker) Sync() {
+	done := make(chan struct{})
+	w.syncs <- done
+	<-done
+}
+
+func (w *Worker) add(event cdc.SyncEvent) {
+	w.batch.Add(event)
+	if w.batch.Len() >= w.BatchSize {
+		w.flush()
+	} else if w.flushTimer == nil {
+		w.flushTimer = time.After(w.BatchWindow)
+	}
+}
+
+func (w *Worker) annotate(feedback gsheets.Feedback) {
+	if w.sm == nil {
+		return
+	}
+	if err := w.sm.ApplyFeedback(feedback); err != nil {
+		log.Printf("Error annotating cells: %v", err)
+	}
+}
+
+func (w *Worker) flush() {
+	w.flushTimer = nil
+
+	var unsaved []cdc.SyncEvent
+	if w.batch.Len() > 0 {
+		events := w.batch.Events()
+
+		if w.sm == nil {
+			log.Printf("Sheet Manager not ready, moving %d events to the outbox", len(events))
+			unsaved = w.Queue(events, errors.New("sheet manager not ready"))
+		} else {
+			log.Printf("Processing batch of %d rows", len(events))
+			if err := w.sm.ApplyBatch(events); err != nil {
+				log.Printf("Error syncing batch, moving it to the outbox: %v", err)
+				unsaved = w.Queue(events, err)
+			}
+		}
+	}
+
+	w.batch.Retain(unsaved)
+	if len(unsaved) > 0 {
+		log.Printf("Holding back the checkpoint, %d events are neither in the sheet nor in the outbox, retrying in %s", len(unsaved), w.RetryInterval)
+		w.flushTimer = time.After(w.RetryInterval)
+		return
+	}
+
+	// Only reached when everything before the checkpoint is in the sheet
+	// or safely in the outbox.
+	if w.batch.Checkpoint != nil {
+		if err := w.SaveCheckpoint(*w.batch.Checkpoint); err != nil {
+			log.Printf("Error saving checkpoint: %v", err)
+		}
+	}
+	w.batch.Reset()
+}